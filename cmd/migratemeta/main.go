@@ -0,0 +1,223 @@
+// Command migratemeta converts the per-repository <repo> and <repo>_perms
+// tables of older installations into the nodes and grants tables. Run it
+// once after applying the migration that creates those tables. Every
+// repository is converted in its own transaction and its old tables are
+// dropped afterwards, so the command can be run again after a failure.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"files_test_rus/internal/app/filemanager"
+
+	"github.com/BurntSushi/toml"
+	"github.com/lib/pq"
+)
+
+var (
+	configPath string
+	dryRun     bool
+	keep       bool
+)
+
+func init() {
+	flag.StringVar(&configPath, "config-path", "configs/filemanager.toml", "path to config file")
+	flag.BoolVar(&dryRun, "dry-run", false, "report what would be converted without changing anything")
+	flag.BoolVar(&keep, "keep", false, "keep the per-repository tables after converting them")
+}
+
+func main() {
+	flag.Parse()
+	config := filemanager.NewConfig()
+	if _, err := toml.DecodeFile(configPath, config); err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := sql.Open("postgres", config.DatabaseURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	repos, err := repositories(ctx, db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := checkCollisions(repos); err != nil {
+		log.Fatal(err)
+	}
+
+	for id, repo := range repos {
+		nodes, grants, err := convert(ctx, db, id, repo)
+		if err != nil {
+			log.Fatalf("%s: %v", repo, err)
+		}
+		log.Printf("%s: %d nodes, %d grants", repo, nodes, grants)
+	}
+}
+
+// schemaTables are the tables of the fixed schema. A repository named like
+// one of them never had a table of its own, since CreateRepository used
+// CREATE TABLE IF NOT EXISTS, so they are never copied from or dropped.
+var schemaTables = map[string]bool{
+	"users": true, "roles": true, "repositories": true, "repository_admins": true,
+	"nodes": true, "grants": true, "groups": true, "user_groups": true,
+	"refresh_tokens": true, "revoked_tokens": true, "service_accounts": true, "api_keys": true,
+	"access_requests": true, "perms_audit": true, "schema_migrations": true,
+}
+
+func repositories(ctx context.Context, db *sql.DB) (map[int]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, repo FROM repositories")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	repos := map[int]string{}
+	for rows.Next() {
+		var (
+			id   int
+			repo string
+		)
+		if err := rows.Scan(&id, &repo); err != nil {
+			return nil, err
+		}
+		repos[id] = repo
+	}
+
+	return repos, rows.Err()
+}
+
+// checkCollisions refuses repositories whose names differ only in case. They
+// shared one legacy table, so there is no telling which of them a row
+// belongs to; they have to be sorted out by hand before converting.
+func checkCollisions(repos map[int]string) error {
+	byTable := map[string][]string{}
+	for _, repo := range repos {
+		if table, ok := legacyTable(repo); ok {
+			byTable[table] = append(byTable[table], repo)
+		}
+	}
+
+	var collisions []string
+	for table, names := range byTable {
+		if len(names) > 1 {
+			sort.Strings(names)
+			collisions = append(collisions, fmt.Sprintf("%s (%s)", table, strings.Join(names, ", ")))
+		}
+	}
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		return fmt.Errorf("repositories share legacy tables, nothing converted: %s", strings.Join(collisions, "; "))
+	}
+
+	return nil
+}
+
+// convert copies the tables of one repository and returns how many nodes and
+// grants were copied. Tables that do not exist are skipped, and grants are
+// not copied again into a repository that already has some.
+func convert(ctx context.Context, db *sql.DB, id int, repo string) (int64, int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var nodes, grants int64
+
+	nodesTable, ok := legacyTable(repo)
+	if !ok {
+		log.Printf("%s: not a valid table name, nothing to convert", repo)
+		return 0, 0, nil
+	}
+	permsTable := nodesTable + "_perms"
+
+	if ok, err := tableExists(ctx, tx, nodesTable); err != nil {
+		return 0, 0, err
+	} else if ok {
+		res, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO nodes (repository_id, path)
+			SELECT $1, path FROM %s ON CONFLICT (repository_id, path) DO NOTHING`, pq.QuoteIdentifier(nodesTable)), id)
+		if err != nil {
+			return 0, 0, err
+		}
+		if nodes, err = res.RowsAffected(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if ok, err := tableExists(ctx, tx, permsTable); err != nil {
+		return 0, 0, err
+	} else if ok {
+		var converted bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM grants WHERE repository_id = $1)", id).Scan(&converted); err != nil {
+			return 0, 0, err
+		}
+
+		if !converted {
+			res, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO grants (repository_id, subject_type, subject, path, permission, effect, valid_from, valid_until)
+				SELECT $1, subject_type, role_title, path, permission, effect, valid_from, valid_until FROM %s ORDER BY id`,
+				pq.QuoteIdentifier(permsTable)), id)
+			if err != nil {
+				return 0, 0, err
+			}
+			if grants, err = res.RowsAffected(); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	if dryRun {
+		return nodes, grants, nil
+	}
+
+	if !keep {
+		for _, table := range []string{nodesTable, permsTable} {
+			if schemaTables[table] {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, "DROP TABLE IF EXISTS "+pq.QuoteIdentifier(table)); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	return nodes, grants, tx.Commit()
+}
+
+// identifier matches the unquoted identifiers CreateRepository used to put
+// repository names into CREATE TABLE with.
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*$`)
+
+// legacyTable returns the name of the table an older CreateRepository
+// created for repo. The name was not quoted, so Postgres folded it to lower
+// case, and repositories whose name is no identifier never got a table.
+func legacyTable(repo string) (string, bool) {
+	// Leave room for the _perms suffix within the 63 bytes of an identifier.
+	if !identifier.MatchString(repo) || len(repo)+len("_perms") > 63 {
+		return "", false
+	}
+
+	return strings.ToLower(repo), true
+}
+
+func tableExists(ctx context.Context, tx *sql.Tx, table string) (bool, error) {
+	if schemaTables[table] {
+		return false, nil
+	}
+
+	var exists bool
+	err := tx.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", pq.QuoteIdentifier(table)).Scan(&exists)
+
+	return exists, err
+}
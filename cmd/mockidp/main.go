@@ -0,0 +1,48 @@
+// Command mockidp is a minimal OpenID Connect provider for trying out the
+// file manager's OIDC login locally. It signs every authorization request in
+// as the user given on the command line (or in the login_hint parameter) and
+// must never be exposed outside a developer machine.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+
+	"files_test_rus/internal/app/oidc/oidctest"
+)
+
+var (
+	addr         string
+	issuer       string
+	clientID     string
+	clientSecret string
+	email        string
+	groups       string
+)
+
+func init() {
+	flag.StringVar(&addr, "addr", ":9000", "listen address")
+	flag.StringVar(&issuer, "issuer", "http://localhost:9000", "issuer URL, must match the file manager config")
+	flag.StringVar(&clientID, "client-id", "filemanager", "expected client id")
+	flag.StringVar(&clientSecret, "client-secret", "secret", "expected client secret")
+	flag.StringVar(&email, "email", "dev@example.com", "email of the signed in user")
+	flag.StringVar(&groups, "groups", "", "comma separated groups of the signed in user")
+}
+
+func main() {
+	flag.Parse()
+
+	p, err := oidctest.NewProvider(issuer, clientID, clientSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	p.Email = email
+	if groups != "" {
+		p.Groups = strings.Split(groups, ",")
+	}
+
+	log.Printf("mock identity provider listening on %s as %s", addr, issuer)
+	log.Fatal(http.ListenAndServe(addr, p.Handler()))
+}
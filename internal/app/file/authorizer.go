@@ -0,0 +1,83 @@
+package file
+
+import (
+	"context"
+	"strings"
+)
+
+// Principal is the caller an access decision is made for.
+type Principal struct {
+	RoleId string
+	Groups []string
+	UserId string
+	Email  string
+}
+
+// Authorizer decides whether a principal may perform an action on a path.
+// Paths are relative to the bucket prefix and start with the repository
+// name; the empty path stands for the whole store.
+type Authorizer interface {
+	Can(ctx context.Context, principal Principal, action Permission, path string) (bool, error)
+	Explain(ctx context.Context, principal Principal, action Permission, path string) (*Explanation, error)
+	Effective(ctx context.Context, principal Principal, path string) (Permission, error)
+	// EffectiveAll returns the Effective permission on each of paths. The
+	// grants of the principal are loaded once per repository.
+	EffectiveAll(ctx context.Context, principal Principal, paths []string) ([]Permission, error)
+	// AdminLevel reports how the principal administers repo: LevelAdmin for
+	// global administrators, LevelOwner, LevelManager, or "" for none.
+	AdminLevel(ctx context.Context, principal Principal, repo string) (string, error)
+}
+
+// LevelAdmin is the administrator level of the global "admin" role.
+const LevelAdmin = "admin"
+
+// EffectivePermission lists what the caller may do on a path.
+type EffectivePermission struct {
+	Path        string   `json:"path"`
+	Permissions []string `json:"permissions"`
+}
+
+// Explanation describes how an access decision was reached.
+type Explanation struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	Role   string `json:"role"`
+	// InheritedRoles are the ancestors of Role, nearest first. Their grants
+	// count as grants of Role.
+	InheritedRoles []string `json:"inherited_roles,omitempty"`
+	// Level is how the principal administers the repository of Path, if at
+	// all.
+	Level   string        `json:"level,omitempty"`
+	Steps   []ExplainStep `json:"steps"`
+	Allowed bool          `json:"allowed"`
+	Reason  string        `json:"reason"`
+}
+
+// ExplainStep is one path checked during the ancestor walk. Permission is
+// the union of the allow Grants and Denied the union of the deny ones.
+type ExplainStep struct {
+	Path       string        `json:"path"`
+	Grants     []RepoPermsId `json:"grants"`
+	Permission string        `json:"permission,omitempty"`
+	Denied     string        `json:"denied,omitempty"`
+}
+
+// principal builds the principal of the caller from the values the
+// authorization middleware put in the request context.
+func principal(ctx context.Context) Principal {
+	p := Principal{}
+	p.RoleId, _ = ctx.Value("role").(string)
+	p.Groups, _ = ctx.Value("groups").([]string)
+	p.UserId, _ = ctx.Value("user_id").(string)
+	p.Email, _ = ctx.Value("email").(string)
+
+	return p
+}
+
+// objectPrefix starts the name of every object of the file manager.
+const objectPrefix = "backend/"
+
+// ObjectPath turns an object name into the path grants are recorded for.
+func ObjectPath(name string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(name, objectPrefix), "/")
+}
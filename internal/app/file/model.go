@@ -1,9 +1,9 @@
 package file
 
 import (
+	"io"
 	"mime/multipart"
-
-	"github.com/minio/minio-go/v7"
+	"time"
 )
 
 type FileNames struct {
@@ -28,10 +28,21 @@ type Upload struct {
 }
 
 type File struct {
-	Id   string        `json:"id"`
-	Size int64         `json:"size"`
-	Type string        `json:"type"`
-	Obj  *minio.Object `json:"-"`
+	Id      string            `json:"id"`
+	Size    int64             `json:"size"`
+	Type    string            `json:"type"`
+	ETag    string            `json:"etag"`
+	ModTime time.Time         `json:"mod_time"`
+	Obj     io.ReadSeekCloser `json:"-"`
+}
+
+// ObjectInfo describes an object kept by an ObjectStore.
+type ObjectInfo struct {
+	Name        string
+	Size        int64
+	ContentType string
+	ETag        string
+	ModTime     time.Time
 }
 
 type Dir struct {
@@ -55,15 +66,90 @@ type RepoFiles struct {
 	Name string `json:"path"`
 }
 
+// Grant subjects. For SubjectRole and SubjectGroup RoleTitle holds the title
+// of the role or group, for SubjectUser the id or the email of the user.
+const (
+	SubjectRole  = "role"
+	SubjectGroup = "group"
+	SubjectUser  = "user"
+)
+
+// Grant effects. A deny takes capabilities away from the allow grants of
+// the same path and of paths further up.
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// Repository administrator levels. Managers may manage the grants and
+// settings of a repository; owners may also appoint other administrators.
+const (
+	LevelOwner   = "owner"
+	LevelManager = "manager"
+)
+
+// RepoAdmin makes a subject an administrator of one repository. Subject is
+// named the same way as RoleTitle of a grant.
+type RepoAdmin struct {
+	SubjectType string `json:"subject_type"`
+	Subject     string `json:"subject"`
+	Level       string `json:"level"`
+}
+
 type RepoPermsId struct {
-	Id         int    `json:"id"`
-	RoleTitle  string `json:"role_title"`
-	Path       string `json:"path"`
-	Permission string `json:"permission"`
+	Id          int        `json:"id"`
+	SubjectType string     `json:"subject_type"`
+	RoleTitle   string     `json:"role_title"`
+	Path        string     `json:"path"`
+	Permission  string     `json:"permission"`
+	Effect      string     `json:"effect"`
+	ValidFrom   *time.Time `json:"valid_from,omitempty"`
+	ValidUntil  *time.Time `json:"valid_until,omitempty"`
+}
+
+// grant returns the grant without its id.
+func (g RepoPermsId) grant() RepoPerms {
+	return RepoPerms{
+		SubjectType: g.SubjectType,
+		RoleTitle:   g.RoleTitle,
+		Path:        g.Path,
+		Permission:  g.Permission,
+		Effect:      g.Effect,
+		ValidFrom:   g.ValidFrom,
+		ValidUntil:  g.ValidUntil,
+	}
 }
 
 type RepoPerms struct {
-	RoleTitle  string `json:"role_title"`
-	Path       string `json:"path"`
-	Permission string `json:"permission"`
+	SubjectType string     `json:"subject_type"`
+	RoleTitle   string     `json:"role_title"`
+	Path        string     `json:"path"`
+	Permission  string     `json:"permission"`
+	Effect      string     `json:"effect"`
+	ValidFrom   *time.Time `json:"valid_from,omitempty"`
+	ValidUntil  *time.Time `json:"valid_until,omitempty"`
+}
+
+// Access request states.
+const (
+	RequestPending  = "pending"
+	RequestApproved = "approved"
+	RequestRejected = "rejected"
+)
+
+// AccessRequest asks for a permission on a path on behalf of a user. An
+// approved request becomes a user grant on its repository.
+type AccessRequest struct {
+	Id            int        `json:"id"`
+	UserId        int        `json:"user_id"`
+	Email         string     `json:"email"`
+	Repo          string     `json:"repo"`
+	Path          string     `json:"path"`
+	Permission    string     `json:"permission"`
+	Justification string     `json:"justification"`
+	Status        string     `json:"status"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DecidedBy     *int       `json:"decided_by,omitempty"`
+	DecidedAt     *time.Time `json:"decided_at,omitempty"`
+	Note          string     `json:"note,omitempty"`
 }
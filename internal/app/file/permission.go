@@ -0,0 +1,90 @@
+package file
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidPermission = errors.New("permission must be a comma separated list of list, read, write, delete, share or manage")
+
+// Permission is a set of capabilities granted on a path. It is stored in the
+// grants table as the comma separated capability names.
+type Permission uint8
+
+const (
+	// PermList allows seeing the path in directory listings.
+	PermList Permission = 1 << iota
+	// PermRead allows downloading files.
+	PermRead
+	// PermWrite allows uploading files and creating entries. Renaming or
+	// moving an entry needs it on both ends, and PermDelete on the source.
+	PermWrite
+	// PermDelete allows removing files and directories.
+	PermDelete
+	// PermShare allows handing the path out to other subjects: adding,
+	// changing and removing grants on it and approving requests for it.
+	PermShare
+	// PermManage allows managing the grants of the path.
+	PermManage
+
+	// PermAll holds every capability.
+	PermAll = PermList | PermRead | PermWrite | PermDelete | PermShare | PermManage
+)
+
+var permissionNames = []struct {
+	perm Permission
+	name string
+}{
+	{PermList, "list"},
+	{PermRead, "read"},
+	{PermWrite, "write"},
+	{PermDelete, "delete"},
+	{PermShare, "share"},
+	{PermManage, "manage"},
+}
+
+// ParsePermission parses a comma separated list of capability names. Unknown
+// names and empty lists are rejected.
+func ParsePermission(s string) (Permission, error) {
+	var p Permission
+	for _, name := range strings.Split(s, ",") {
+		perm, ok := capability(strings.TrimSpace(name))
+		if !ok {
+			return 0, ErrInvalidPermission
+		}
+		p |= perm
+	}
+
+	return p, nil
+}
+
+func capability(name string) (Permission, bool) {
+	for _, n := range permissionNames {
+		if n.name == name {
+			return n.perm, true
+		}
+	}
+
+	return 0, false
+}
+
+// Has reports whether every capability of want is in p.
+func (p Permission) Has(want Permission) bool {
+	return p&want == want
+}
+
+// Names lists the names of the capabilities in p.
+func (p Permission) Names() []string {
+	names := make([]string, 0, len(permissionNames))
+	for _, n := range permissionNames {
+		if p.Has(n.perm) {
+			names = append(names, n.name)
+		}
+	}
+
+	return names
+}
+
+func (p Permission) String() string {
+	return strings.Join(p.Names(), ",")
+}
@@ -2,21 +2,48 @@ package file
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 
 	_ "github.com/lib/pq"
 	"github.com/sirupsen/logrus"
 )
 
+var (
+	ErrOutOfScope       = errors.New("repository is outside of the credential scope")
+	ErrInvalidSubject   = errors.New("subject_type must be one of role, group or user")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrInvalidEffect    = errors.New("effect must be either allow or deny")
+	ErrInvalidValidity  = errors.New("valid_until must be after valid_from")
+	ErrInvalidLevel     = errors.New("level must be either owner or manager")
+	ErrInvalidRequest   = errors.New("an access request needs a path and a permission")
+	ErrInvalidRepoName  = errors.New("repository name must not be empty or contain a slash")
+	ErrRequestNotFound  = errors.New("access request not found")
+	ErrRepoNotFound     = errors.New("repository not found")
+	ErrRequestDecided   = errors.New("access request has already been decided")
+	ErrGrantNotFound    = errors.New("grant not found")
+	ErrIsDirectory      = errors.New("path is a directory")
+	ErrDestinationTaken = errors.New("destination already exists")
+)
+
+// service keeps the content of files in objects and everything known about
+// them in metadata, and keeps the two in step.
 type service struct {
-	storage Client
-	logger  *logrus.Logger
+	objects    ObjectStore
+	metadata   MetadataStore
+	authorizer Authorizer
+	logger     *logrus.Logger
 }
 
-func NewService(minioStorage Client, logger *logrus.Logger) (Service, error) {
+func NewService(objects ObjectStore, metadata MetadataStore, authorizer Authorizer, logger *logrus.Logger) (Service, error) {
 	return &service{
-		storage: minioStorage,
-		logger:  logger,
+		objects:    objects,
+		metadata:   metadata,
+		authorizer: authorizer,
+		logger:     logger,
 	}, nil
 }
 
@@ -28,6 +55,7 @@ type Service interface {
 	MoveFile(context.Context, Move) error
 
 	GetFiles(context.Context) ([]SubDir, error)
+	GetEffectivePermissions(context.Context, string) (*[]EffectivePermission, error)
 
 	CreateDirectory(context.Context, string) error
 	RenameDirectory(context.Context, Rename) error
@@ -41,10 +69,37 @@ type Service interface {
 	AddRepositoryPerms(context.Context, string, RepoPerms) error
 	EditRepositoryPerms(context.Context, string, RepoPermsId) error
 	RemoveRepositoryPerms(context.Context, string, RepoPerms) error
+	GetRepositoryAdmins(context.Context, string) (*[]RepoAdmin, error)
+	AddRepositoryAdmin(context.Context, string, RepoAdmin) error
+	RemoveRepositoryAdmin(context.Context, string, RepoAdmin) error
+	RequestAccess(context.Context, AccessRequest) (*AccessRequest, error)
+	GetAccessRequests(context.Context, string) (*[]AccessRequest, error)
+	DecideAccessRequest(context.Context, int, bool, string) (*AccessRequest, error)
+
+	ExportPerms(context.Context, string) (*[]RepoGrants, error)
+	ImportPerms(context.Context, []RepoGrants, bool) (*[]RepoPermsDiff, error)
+
+	Explain(context.Context, Principal, Permission, string) (*Explanation, error)
 }
 
 func (s *service) RemoveRepositoryPerms(ctx context.Context, repoName string, repoPerm RepoPerms) error {
-	if err := s.storage.RemoveRepositoryPerms(ctx, repoName, repoPerm); err != nil {
+	if !inScope(ctx, repoName) {
+		return ErrOutOfScope
+	}
+
+	if err := s.authorize(ctx, PermManage, repoName); err != nil {
+		return err
+	}
+
+	if err := repoPerm.normalize(); err != nil {
+		return err
+	}
+
+	if err := s.authorizeGrant(ctx, repoName, repoPerm); err != nil {
+		return err
+	}
+
+	if err := s.metadata.RemoveRepositoryPerms(ctx, repoName, repoPerm); err != nil {
 		return fmt.Errorf("obj err: %v", err)
 	}
 
@@ -52,7 +107,34 @@ func (s *service) RemoveRepositoryPerms(ctx context.Context, repoName string, re
 }
 
 func (s *service) EditRepositoryPerms(ctx context.Context, repoName string, repoPerm RepoPermsId) error {
-	if err := s.storage.EditRepositoryPerms(ctx, repoName, repoPerm); err != nil {
+	if !inScope(ctx, repoName) {
+		return ErrOutOfScope
+	}
+
+	if err := s.authorize(ctx, PermManage, repoName); err != nil {
+		return err
+	}
+
+	grant := repoPerm.grant()
+	if err := grant.normalize(); err != nil {
+		return err
+	}
+	repoPerm.SubjectType, repoPerm.Permission, repoPerm.Effect = grant.SubjectType, grant.Permission, grant.Effect
+
+	// Changing a grant takes it back from where it was and hands it out
+	// anew, so the caller needs share on both paths.
+	old, err := s.findGrant(ctx, repoName, repoPerm.Id)
+	if err != nil {
+		return err
+	}
+	if err := s.authorizeGrant(ctx, repoName, old); err != nil {
+		return err
+	}
+	if err := s.authorizeGrant(ctx, repoName, grant); err != nil {
+		return err
+	}
+
+	if err := s.metadata.EditRepositoryPerms(ctx, repoName, repoPerm); err != nil {
 		return fmt.Errorf("obj err: %v", err)
 	}
 
@@ -60,15 +142,352 @@ func (s *service) EditRepositoryPerms(ctx context.Context, repoName string, repo
 }
 
 func (s *service) AddRepositoryPerms(ctx context.Context, repoName string, repoPerm RepoPerms) error {
-	if err := s.storage.AddRepositoryPerms(ctx, repoName, repoPerm); err != nil {
+	if !inScope(ctx, repoName) {
+		return ErrOutOfScope
+	}
+
+	if err := s.authorize(ctx, PermManage, repoName); err != nil {
+		return err
+	}
+
+	if err := repoPerm.normalize(); err != nil {
+		return err
+	}
+
+	if err := s.authorizeGrant(ctx, repoName, repoPerm); err != nil {
+		return err
+	}
+
+	if err := s.metadata.AddRepositoryPerms(ctx, repoName, repoPerm); err != nil {
 		return fmt.Errorf("obj err: %v", err)
 	}
 
 	return nil
 }
 
+// RequestAccess files a request of the calling user for a permission on a
+// path. Only users can ask; service accounts get their access from their
+// role.
+func (s *service) RequestAccess(ctx context.Context, req AccessRequest) (*AccessRequest, error) {
+	p := principal(ctx)
+	userId, err := strconv.Atoi(p.UserId)
+	if err != nil {
+		return nil, ErrPermissionDenied
+	}
+
+	path := ObjectPath(req.Path)
+	if path == "" || req.Permission == "" {
+		return nil, ErrInvalidRequest
+	}
+
+	if !inScope(ctx, path) {
+		return nil, ErrOutOfScope
+	}
+
+	perm, err := ParsePermission(req.Permission)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := strings.Split(path, "/")[0]
+	exists, err := s.metadata.RepositoryExists(ctx, repo)
+	if err != nil {
+		return nil, fmt.Errorf("obj err: %v", err)
+	}
+	if !exists {
+		return nil, ErrRepoNotFound
+	}
+
+	ar := &AccessRequest{
+		UserId:        userId,
+		Email:         p.Email,
+		Repo:          repo,
+		Path:          path,
+		Permission:    perm.String(),
+		Justification: req.Justification,
+		Status:        RequestPending,
+	}
+	if err := s.metadata.CreateAccessRequest(ctx, ar); err != nil {
+		return nil, fmt.Errorf("obj err: %v", err)
+	}
+
+	return ar, nil
+}
+
+// GetAccessRequests lists the requests with the given status, or all of
+// them when status is empty, for the repositories the caller manages.
+func (s *service) GetAccessRequests(ctx context.Context, status string) (*[]AccessRequest, error) {
+	requests, err := s.metadata.GetAccessRequests(ctx, status)
+	if err != nil {
+		return nil, fmt.Errorf("obj err: %v", err)
+	}
+
+	p := principal(ctx)
+	queue := []AccessRequest{}
+	for _, ar := range *requests {
+		if !inScope(ctx, ar.Repo) {
+			continue
+		}
+
+		ok, err := s.authorizer.Can(ctx, p, PermManage, ar.Repo)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			queue = append(queue, ar)
+		}
+	}
+
+	return &queue, nil
+}
+
+// DecideAccessRequest approves or rejects a pending request. Approving it
+// grants the requested permission to the user on the requested path.
+func (s *service) DecideAccessRequest(ctx context.Context, id int, approve bool, note string) (*AccessRequest, error) {
+	ar, err := s.metadata.FindAccessRequest(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !inScope(ctx, ar.Repo) {
+		return nil, ErrOutOfScope
+	}
+
+	if err := s.authorize(ctx, PermManage, ar.Repo); err != nil {
+		return nil, err
+	}
+
+	if ar.Status != RequestPending {
+		return nil, ErrRequestDecided
+	}
+
+	if decidedBy, err := strconv.Atoi(principal(ctx).UserId); err == nil {
+		ar.DecidedBy = &decidedBy
+	}
+	ar.Note = note
+
+	var grant *RepoPerms
+	if approve {
+		ar.Status = RequestApproved
+		grant = &RepoPerms{
+			SubjectType: SubjectUser,
+			RoleTitle:   strconv.Itoa(ar.UserId),
+			Path:        ar.Path,
+			Permission:  ar.Permission,
+			Effect:      EffectAllow,
+		}
+		if err := s.authorizeGrant(ctx, ar.Repo, *grant); err != nil {
+			return nil, err
+		}
+	} else {
+		ar.Status = RequestRejected
+	}
+
+	if err := s.metadata.DecideAccessRequest(ctx, ar, grant); err != nil {
+		if errors.Is(err, ErrRequestDecided) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("obj err: %v", err)
+	}
+
+	return ar, nil
+}
+
+func (s *service) GetRepositoryAdmins(ctx context.Context, repoName string) (*[]RepoAdmin, error) {
+	if !inScope(ctx, repoName) {
+		return nil, ErrOutOfScope
+	}
+
+	if err := s.authorize(ctx, PermManage, repoName); err != nil {
+		return nil, err
+	}
+
+	admins, err := s.metadata.GetRepositoryAdmins(ctx, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("obj err: %v", err)
+	}
+
+	return admins, nil
+}
+
+func (s *service) AddRepositoryAdmin(ctx context.Context, repoName string, admin RepoAdmin) error {
+	if err := s.requireOwner(ctx, repoName); err != nil {
+		return err
+	}
+
+	var err error
+	if admin.SubjectType, err = subjectType(admin.SubjectType); err != nil {
+		return err
+	}
+
+	if admin.Level != LevelOwner && admin.Level != LevelManager {
+		return ErrInvalidLevel
+	}
+
+	if err := s.metadata.AddRepositoryAdmin(ctx, repoName, admin); err != nil {
+		return fmt.Errorf("obj err: %v", err)
+	}
+
+	return nil
+}
+
+func (s *service) RemoveRepositoryAdmin(ctx context.Context, repoName string, admin RepoAdmin) error {
+	if err := s.requireOwner(ctx, repoName); err != nil {
+		return err
+	}
+
+	var err error
+	if admin.SubjectType, err = subjectType(admin.SubjectType); err != nil {
+		return err
+	}
+
+	if err := s.metadata.RemoveRepositoryAdmin(ctx, repoName, admin); err != nil {
+		return fmt.Errorf("obj err: %v", err)
+	}
+
+	return nil
+}
+
+// requireOwner allows only global administrators and owners of the
+// repository to appoint its administrators.
+func (s *service) requireOwner(ctx context.Context, repoName string) error {
+	if !inScope(ctx, repoName) {
+		return ErrOutOfScope
+	}
+
+	level, err := s.authorizer.AdminLevel(ctx, principal(ctx), repoName)
+	if err != nil {
+		return err
+	}
+	if level != LevelAdmin && level != LevelOwner {
+		return ErrPermissionDenied
+	}
+
+	return nil
+}
+
+// ExportPerms returns the grants of repoName, or of every repository the
+// caller manages when repoName is empty.
+func (s *service) ExportPerms(ctx context.Context, repoName string) (*[]RepoGrants, error) {
+	var repos []string
+	if repoName != "" {
+		repos = []string{repoName}
+	} else {
+		all, err := s.GetRepositories(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if all != nil {
+			for _, repo := range *all {
+				repos = append(repos, repo.Name)
+			}
+		}
+	}
+
+	grants := []RepoGrants{}
+	for _, repo := range repos {
+		perms, err := s.GetRepositoryPerms(ctx, repo)
+		if err != nil {
+			return nil, err
+		}
+
+		export := RepoGrants{Repo: repo, Grants: []RepoPerms{}}
+		if perms != nil {
+			for _, p := range *perms {
+				export.Grants = append(export.Grants, p.grant())
+			}
+		}
+		grants = append(grants, export)
+	}
+
+	return &grants, nil
+}
+
+// ImportPerms makes the grants of every repository in the file exactly the
+// ones listed there. Repositories missing from the file are left alone. The
+// changes are computed and applied in a single transaction unless dryRun is
+// set, in which case they are only reported.
+func (s *service) ImportPerms(ctx context.Context, grants []RepoGrants, dryRun bool) (*[]RepoPermsDiff, error) {
+	repos := []string{}
+	desired := map[string][]RepoPerms{}
+	for _, repo := range grants {
+		if _, ok := desired[repo.Repo]; ok {
+			return nil, fmt.Errorf("%w: repository %s appears twice", ErrDuplicateGrant, repo.Repo)
+		}
+
+		if !inScope(ctx, repo.Repo) {
+			return nil, ErrOutOfScope
+		}
+		if err := s.authorize(ctx, PermManage, repo.Repo); err != nil {
+			return nil, err
+		}
+
+		for i := range repo.Grants {
+			if err := repo.Grants[i].normalize(); err != nil {
+				return nil, err
+			}
+		}
+
+		repos = append(repos, repo.Repo)
+		desired[repo.Repo] = append([]RepoPerms{}, repo.Grants...)
+	}
+
+	diff := func(repo string, current []RepoPermsId) (*RepoPermsDiff, error) {
+		d, err := diffGrants(repo, current, desired[repo])
+		if err != nil {
+			return nil, err
+		}
+		if err := s.authorizeDiff(ctx, d); err != nil {
+			return nil, err
+		}
+
+		return d, nil
+	}
+
+	if !dryRun {
+		diffs, err := s.metadata.ApplyPermsChanges(ctx, repos, diff)
+		if err != nil {
+			if errors.Is(err, ErrDuplicateGrant) || errors.Is(err, ErrPermissionDenied) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("obj err: %v", err)
+		}
+
+		return &diffs, nil
+	}
+
+	diffs := []RepoPermsDiff{}
+	for _, repo := range repos {
+		current, err := s.metadata.GetRepositoryPerms(ctx, repo)
+		if err != nil {
+			return nil, fmt.Errorf("obj err: %v", err)
+		}
+
+		var existing []RepoPermsId
+		if current != nil {
+			existing = *current
+		}
+
+		d, err := diff(repo, existing)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, *d)
+	}
+
+	return &diffs, nil
+}
+
 func (s *service) GetRepositoryPerms(ctx context.Context, repoName string) (*[]RepoPermsId, error) {
-	object, err := s.storage.GetRepositoryPerms(ctx, repoName)
+	if !inScope(ctx, repoName) {
+		return nil, ErrOutOfScope
+	}
+
+	if err := s.authorize(ctx, PermManage, repoName); err != nil {
+		return nil, err
+	}
+
+	object, err := s.metadata.GetRepositoryPerms(ctx, repoName)
 	if err != nil {
 		return nil, fmt.Errorf("obj err: %v", err)
 	}
@@ -76,7 +495,15 @@ func (s *service) GetRepositoryPerms(ctx context.Context, repoName string) (*[]R
 }
 
 func (s *service) GetRepositoryFiles(ctx context.Context, repoName string) (*[]RepoFiles, error) {
-	object, err := s.storage.GetRepositoryFiles(ctx, repoName)
+	if !inScope(ctx, repoName) {
+		return nil, ErrOutOfScope
+	}
+
+	if err := s.authorize(ctx, PermManage, repoName); err != nil {
+		return nil, err
+	}
+
+	object, err := s.metadata.GetRepositoryFiles(ctx, repoName)
 	if err != nil {
 		return nil, fmt.Errorf("obj err: %v", err)
 	}
@@ -84,53 +511,200 @@ func (s *service) GetRepositoryFiles(ctx context.Context, repoName string) (*[]R
 }
 
 func (s *service) GetRepositories(ctx context.Context) (*[]Repos, error) {
-	object, err := s.storage.GetRepositories(ctx)
+	object, err := s.metadata.GetRepositories(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("obj err: %v", err)
 	}
+
+	if object != nil {
+		repos := []Repos{}
+		for _, repo := range *object {
+			if !inScope(ctx, repo.Name) {
+				continue
+			}
+
+			ok, err := s.authorizer.Can(ctx, principal(ctx), PermManage, repo.Name)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				repos = append(repos, repo)
+			}
+		}
+		object = &repos
+	}
+
 	return object, nil
 }
 
 func (s *service) GetFiles(ctx context.Context) ([]SubDir, error) {
-	object, err := s.storage.GetFiles(ctx)
+	object, err := s.listFiles(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("obj err: %v", err)
 	}
 
-	return object, nil
+	names, err := s.listable(ctx, object)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := toTree(names)
+	if tree == nil {
+		return nil, fmt.Errorf("empty data")
+	}
+
+	return tree, nil
 }
 
-func (s *service) GetFile(ctx context.Context, filename string) (*File, error) {
-	obj, err := s.storage.GetFile(ctx, filename)
+// GetEffectivePermissions reports the capabilities of the caller on path, or
+// on every node of the GetFiles tree when path is empty.
+func (s *service) GetEffectivePermissions(ctx context.Context, path string) (*[]EffectivePermission, error) {
+	p := principal(ctx)
+
+	var paths []string
+	if path != "" {
+		if !inScope(ctx, path) {
+			return nil, ErrOutOfScope
+		}
+		paths = []string{ObjectPath(path)}
+	} else {
+		object, err := s.listFiles(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("obj err: %v", err)
+		}
+
+		names, err := s.listable(ctx, object)
+		if err != nil {
+			return nil, err
+		}
+
+		seen := map[string]bool{}
+		for _, name := range names {
+			// The tree shows every directory above a listed entry too.
+			parts := strings.Split(name, "/")
+			for i := range parts {
+				node := strings.Join(parts[:i+1], "/")
+				if !seen[node] {
+					seen[node] = true
+					paths = append(paths, node)
+				}
+			}
+		}
+		sort.Strings(paths)
+	}
+
+	effective, err := s.authorizer.EffectiveAll(ctx, p, paths)
 	if err != nil {
 		return nil, err
 	}
 
-	objectInfo, err := obj.Stat()
+	perms := []EffectivePermission{}
+	for i, node := range paths {
+		perms = append(perms, EffectivePermission{
+			Path:        node,
+			Permissions: effective[i].Names(),
+		})
+	}
+
+	return &perms, nil
+}
+
+// listable keeps the names the caller may list.
+func (s *service) listable(ctx context.Context, names []string) ([]string, error) {
+	var inScopeNames []string
+	for _, name := range names {
+		if inScope(ctx, name) {
+			inScopeNames = append(inScopeNames, name)
+		}
+	}
+
+	perms, err := s.authorizer.EffectiveAll(ctx, principal(ctx), inScopeNames)
 	if err != nil {
-		return nil, fmt.Errorf("obj.stat error: %v", err)
+		return nil, err
+	}
+
+	var listable []string
+	for i, name := range inScopeNames {
+		if perms[i].Has(PermList) {
+			listable = append(listable, name)
+		}
+	}
+
+	return listable, nil
+}
+
+func (s *service) GetFile(ctx context.Context, filename string) (*File, error) {
+	if !inScope(ctx, filename) {
+		return nil, ErrOutOfScope
+	}
+
+	if err := s.authorize(ctx, PermRead, ObjectPath(filename)); err != nil {
+		return nil, err
+	}
+
+	obj, objectInfo, err := s.objects.GetObject(ctx, filename)
+	if err != nil {
+		return nil, err
 	}
 
 	f := File{
-		Id:   objectInfo.Key,
-		Size: objectInfo.Size,
-		Type: objectInfo.ContentType,
-		Obj:  obj,
+		Id:      objectInfo.Name,
+		Size:    objectInfo.Size,
+		Type:    objectInfo.ContentType,
+		ETag:    objectInfo.ETag,
+		ModTime: objectInfo.ModTime,
+		Obj:     obj,
 	}
 
 	return &f, nil
 }
 
 func (s *service) UploadFile(ctx context.Context, file *Upload) error {
-	if err := s.storage.UploadFile(ctx, file.Name, file.Size, file.Data); err != nil {
+	if !inScope(ctx, file.Name) {
+		return ErrOutOfScope
+	}
+
+	if err := s.authorize(ctx, PermWrite, ObjectPath(file.Name)); err != nil {
+		return err
+	}
+
+	if err := s.objects.PutObject(ctx, file.Name, file.Size, file.Type, file.Data); err != nil {
 		return err
 	}
 
+	// The object is stored; a missing node only hides it from the
+	// repository file listing.
+	if err := s.metadata.AddNode(ctx, ObjectPath(file.Name)); err != nil {
+		s.logger.Warnf("failed to record node %s. err: %v", file.Name, err)
+	}
+
 	return nil
 }
 
 func (s *service) RemoveFile(ctx context.Context, fileName string) error {
-	if err := s.storage.RemoveFile(ctx, fileName); err != nil {
+	if !inScope(ctx, fileName) {
+		return ErrOutOfScope
+	}
+
+	if err := s.authorize(ctx, PermDelete, ObjectPath(fileName)); err != nil {
+		return err
+	}
+
+	// Everything below a directory has to be checked on its own, which only
+	// RemoveDirectory does.
+	children, err := s.objects.ListObjects(ctx, directoryPrefix(fileName))
+	if err != nil {
+		return err
+	}
+	if len(children) > 0 {
+		return ErrIsDirectory
+	}
+
+	if err := s.objects.RemoveObject(ctx, fileName); err != nil {
+		return err
+	}
+
+	if err := s.metadata.RemoveNode(ctx, ObjectPath(fileName)); err != nil {
 		return err
 	}
 
@@ -138,7 +712,19 @@ func (s *service) RemoveFile(ctx context.Context, fileName string) error {
 }
 
 func (s *service) RenameFile(ctx context.Context, fileName Rename) error {
-	if err := s.storage.RenameFile(ctx, fileName.Old, fileName.New); err != nil {
+	if !inScope(ctx, fileName.Old) || !inScope(ctx, fileName.New) {
+		return ErrOutOfScope
+	}
+
+	if err := s.authorize(ctx, PermWrite|PermDelete, ObjectPath(fileName.Old)); err != nil {
+		return err
+	}
+
+	if err := s.authorize(ctx, PermWrite, ObjectPath(fileName.New)); err != nil {
+		return err
+	}
+
+	if err := s.moveObject(ctx, fileName.Old, fileName.New); err != nil {
 		return err
 	}
 
@@ -146,7 +732,19 @@ func (s *service) RenameFile(ctx context.Context, fileName Rename) error {
 }
 
 func (s *service) MoveFile(ctx context.Context, param Move) error {
-	if err := s.storage.RenameFile(ctx, param.Src, param.Dst); err != nil {
+	if !inScope(ctx, param.Src) || !inScope(ctx, param.Dst) {
+		return ErrOutOfScope
+	}
+
+	if err := s.authorize(ctx, PermWrite|PermDelete, ObjectPath(param.Src)); err != nil {
+		return err
+	}
+
+	if err := s.authorize(ctx, PermWrite, ObjectPath(param.Dst)); err != nil {
+		return err
+	}
+
+	if err := s.moveObject(ctx, param.Src, param.Dst); err != nil {
 		return err
 	}
 
@@ -154,7 +752,19 @@ func (s *service) MoveFile(ctx context.Context, param Move) error {
 }
 
 func (s *service) CreateDirectory(ctx context.Context, dir string) error {
-	if err := s.storage.CreateDirectory(ctx, dir); err != nil {
+	if !inScope(ctx, dir) {
+		return ErrOutOfScope
+	}
+
+	if err := s.authorize(ctx, PermWrite, ObjectPath(dir)); err != nil {
+		return err
+	}
+
+	if err := s.objects.PutObject(ctx, dir+"/", 0, "", strings.NewReader("")); err != nil {
+		return err
+	}
+
+	if err := s.metadata.AddNode(ctx, ObjectPath(dir)); err != nil {
 		return err
 	}
 
@@ -162,7 +772,25 @@ func (s *service) CreateDirectory(ctx context.Context, dir string) error {
 }
 
 func (s *service) CreateRepository(ctx context.Context, dir string) error {
-	if err := s.storage.CreateRepository(ctx, dir); err != nil {
+	if name := ObjectPath(dir); name == "" || strings.Contains(name, "/") {
+		return ErrInvalidRepoName
+	}
+
+	if !inScope(ctx, dir) {
+		return ErrOutOfScope
+	}
+
+	// The repository does not exist yet, so only a grant on the whole store
+	// can allow creating it.
+	if err := s.authorize(ctx, PermManage, ""); err != nil {
+		return err
+	}
+
+	if err := s.objects.PutObject(ctx, dir+"/", 0, "", strings.NewReader("")); err != nil {
+		return err
+	}
+
+	if err := s.metadata.AddRepository(ctx, ObjectPath(dir)); err != nil {
 		return err
 	}
 
@@ -170,7 +798,19 @@ func (s *service) CreateRepository(ctx context.Context, dir string) error {
 }
 
 func (s *service) RenameDirectory(ctx context.Context, dirName Rename) error {
-	if err := s.storage.RenameDirectory(ctx, dirName.Old, dirName.New); err != nil {
+	if !inScope(ctx, dirName.Old) || !inScope(ctx, dirName.New) {
+		return ErrOutOfScope
+	}
+
+	if err := s.authorize(ctx, PermWrite|PermDelete, ObjectPath(dirName.Old)); err != nil {
+		return err
+	}
+
+	if err := s.authorize(ctx, PermWrite, ObjectPath(dirName.New)); err != nil {
+		return err
+	}
+
+	if err := s.moveDirectory(ctx, dirName.Old, dirName.New); err != nil {
 		return err
 	}
 
@@ -178,7 +818,19 @@ func (s *service) RenameDirectory(ctx context.Context, dirName Rename) error {
 }
 
 func (s *service) MoveDirectory(ctx context.Context, dirName Move) error {
-	if err := s.storage.RenameDirectory(ctx, dirName.Src, dirName.Dst); err != nil {
+	if !inScope(ctx, dirName.Src) || !inScope(ctx, dirName.Dst) {
+		return ErrOutOfScope
+	}
+
+	if err := s.authorize(ctx, PermWrite|PermDelete, ObjectPath(dirName.Src)); err != nil {
+		return err
+	}
+
+	if err := s.authorize(ctx, PermWrite, ObjectPath(dirName.Dst)); err != nil {
+		return err
+	}
+
+	if err := s.moveDirectory(ctx, dirName.Src, dirName.Dst); err != nil {
 		return err
 	}
 
@@ -186,9 +838,330 @@ func (s *service) MoveDirectory(ctx context.Context, dirName Move) error {
 }
 
 func (s *service) RemoveDirectory(ctx context.Context, dirName string) error {
-	if err := s.storage.RemoveDirectory(ctx, dirName); err != nil {
+	if !inScope(ctx, dirName) {
+		return ErrOutOfScope
+	}
+
+	if err := s.authorize(ctx, PermDelete, ObjectPath(dirName)); err != nil {
+		return err
+	}
+
+	names, err := s.objects.ListObjects(ctx, directoryPrefix(dirName))
+	if err != nil {
+		return err
+	}
+
+	// A grant below the directory may deny what the one on it allows.
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = ObjectPath(name)
+	}
+	if err := s.authorizeAll(ctx, PermDelete, paths); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := s.objects.RemoveObject(ctx, name); err != nil {
+			s.logger.Errorf("remove object error %v", err)
+		}
+	}
+
+	if err := s.metadata.RemoveNodes(ctx, ObjectPath(dirName)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// listFiles lists the paths of every object in the store.
+func (s *service) listFiles(ctx context.Context) ([]string, error) {
+	keys, err := s.objects.ListObjects(ctx, objectPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, key := range keys {
+		if name := ObjectPath(key); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+// moveObject moves a single object together with its node and grants. The
+// original is removed only once the metadata has moved, so a failure on the
+// way leaves everything where it was.
+func (s *service) moveObject(ctx context.Context, old, new string) error {
+	if err := s.ensureAbsent(ctx, new); err != nil {
+		return err
+	}
+
+	if err := s.objects.CopyObject(ctx, old, new); err != nil {
+		return err
+	}
+
+	if err := s.metadata.MoveNodes(ctx, ObjectPath(old), ObjectPath(new)); err != nil {
+		s.discard(ctx, []string{new})
+		return err
+	}
+
+	if err := s.objects.RemoveObject(ctx, old); err != nil {
+		s.logger.Errorf("remove object error %v", err)
+	}
+
+	return nil
+}
+
+// moveDirectory moves every object below old to the same place below new,
+// and the nodes and grants of the directory with them. Nothing is moved
+// unless the caller may move each of the objects, and as in moveObject the
+// originals are removed only once the metadata has moved.
+func (s *service) moveDirectory(ctx context.Context, old, new string) error {
+	oldPrefix, newPrefix := directoryPrefix(old), directoryPrefix(new)
+
+	names, err := s.objects.ListObjects(ctx, oldPrefix)
+	if err != nil {
+		return err
+	}
+
+	sources := make([]string, len(names))
+	targets := make([]string, len(names))
+	for i, name := range names {
+		sources[i] = ObjectPath(name)
+		targets[i] = ObjectPath(newPrefix + strings.TrimPrefix(name, oldPrefix))
+	}
+	if err := s.authorizeAll(ctx, PermWrite|PermDelete, sources); err != nil {
+		return err
+	}
+	if err := s.authorizeAll(ctx, PermWrite, targets); err != nil {
+		return err
+	}
+
+	if err := s.ensureAbsent(ctx, new); err != nil {
+		return err
+	}
+
+	var copies []string
+	for _, name := range names {
+		target := newPrefix + strings.TrimPrefix(name, oldPrefix)
+		if err := s.objects.CopyObject(ctx, name, target); err != nil {
+			s.discard(ctx, copies)
+			return err
+		}
+		copies = append(copies, target)
+	}
+
+	if err := s.metadata.MoveNodes(ctx, ObjectPath(old), ObjectPath(new)); err != nil {
+		s.discard(ctx, copies)
+		return err
+	}
+
+	for _, name := range names {
+		if err := s.objects.RemoveObject(ctx, name); err != nil {
+			s.logger.Errorf("remove object error %v", err)
+		}
+	}
+
+	return nil
+}
+
+// ensureAbsent fails with ErrDestinationTaken when there is an object at
+// name or below it.
+func (s *service) ensureAbsent(ctx context.Context, name string) error {
+	name = strings.TrimSuffix(name, "/")
+
+	names, err := s.objects.ListObjects(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range names {
+		if n == name || strings.HasPrefix(n, directoryPrefix(name)) {
+			return ErrDestinationTaken
+		}
+	}
+
+	return nil
+}
+
+// discard removes the copies made by a move that could not be finished.
+func (s *service) discard(ctx context.Context, names []string) {
+	for _, name := range names {
+		if err := s.objects.RemoveObject(ctx, name); err != nil {
+			s.logger.Errorf("failed to remove copy %s of an unfinished move. err: %v", name, err)
+		}
+	}
+}
+
+// directoryPrefix returns the prefix shared by the names of a directory and
+// everything in it.
+func directoryPrefix(dir string) string {
+	return strings.TrimSuffix(dir, "/") + "/"
+}
+
+// Explain reports how the access decision for principal is reached. Only
+// callers that may manage the whole store can inspect other principals.
+func (s *service) Explain(ctx context.Context, p Principal, action Permission, path string) (*Explanation, error) {
+	if !inScope(ctx, path) {
+		return nil, ErrOutOfScope
+	}
+
+	if err := s.authorize(ctx, PermManage, ""); err != nil {
+		return nil, err
+	}
+
+	return s.authorizer.Explain(ctx, p, action, ObjectPath(path))
+}
+
+// authorize asks the authorizer whether the caller may perform action on
+// path and turns a refusal into ErrPermissionDenied.
+func (s *service) authorize(ctx context.Context, action Permission, path string) error {
+	ok, err := s.authorizer.Can(ctx, principal(ctx), action, path)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrPermissionDenied
+	}
+
+	return nil
+}
+
+// authorizeGrant checks that the caller may hand grant out or take it back,
+// which takes share on the path it is on. Only owners may hand out manage,
+// or managers could appoint further managers.
+func (s *service) authorizeGrant(ctx context.Context, repoName string, grant RepoPerms) error {
+	if err := s.authorize(ctx, PermShare, ObjectPath(grant.Path)); err != nil {
 		return err
 	}
 
+	if perm, err := ParsePermission(grant.Permission); err != nil || perm.Has(PermManage) {
+		return s.requireOwner(ctx, repoName)
+	}
+
+	return nil
+}
+
+// authorizeDiff is authorizeGrant for every grant an import adds, changes
+// or removes.
+func (s *service) authorizeDiff(ctx context.Context, diff *RepoPermsDiff) error {
+	grants := append([]RepoPerms{}, diff.Added...)
+	for _, g := range diff.Removed {
+		grants = append(grants, g.grant())
+	}
+	for _, c := range diff.Changed {
+		grants = append(grants, c.Old.grant(), c.New)
+	}
+
+	for _, g := range grants {
+		if err := s.authorizeGrant(ctx, diff.Repo, g); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findGrant looks up the grant with id in repoName.
+func (s *service) findGrant(ctx context.Context, repoName string, id int) (RepoPerms, error) {
+	perms, err := s.metadata.GetRepositoryPerms(ctx, repoName)
+	if err != nil {
+		return RepoPerms{}, fmt.Errorf("obj err: %v", err)
+	}
+	if perms != nil {
+		for _, g := range *perms {
+			if g.Id == id {
+				return g.grant(), nil
+			}
+		}
+	}
+
+	return RepoPerms{}, ErrGrantNotFound
+}
+
+// authorizeAll is authorize for many paths at once; it fails unless the
+// caller may perform action on every one of them.
+func (s *service) authorizeAll(ctx context.Context, action Permission, paths []string) error {
+	perms, err := s.authorizer.EffectiveAll(ctx, principal(ctx), paths)
+	if err != nil {
+		return err
+	}
+
+	for _, perm := range perms {
+		if !perm.Has(action) {
+			return ErrPermissionDenied
+		}
+	}
+
+	return nil
+}
+
+// inScope reports whether name lies in a repository the caller may reach.
+// API keys can be limited to a list of repositories, sessions are not.
+func inScope(ctx context.Context, name string) bool {
+	repos, _ := ctx.Value("repositories").([]string)
+	if len(repos) == 0 {
+		return true
+	}
+
+	repo := strings.Split(strings.TrimPrefix(name, "backend/"), "/")[0]
+	for _, r := range repos {
+		if r == repo {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalize validates a grant and fills in the defaults of its optional
+// fields.
+func (g *RepoPerms) normalize() error {
+	var err error
+	if g.SubjectType, err = subjectType(g.SubjectType); err != nil {
+		return err
+	}
+
+	perm, err := ParsePermission(g.Permission)
+	if err != nil {
+		return err
+	}
+	g.Permission = perm.String()
+
+	if g.Effect, err = effect(g.Effect); err != nil {
+		return err
+	}
+
+	if g.ValidFrom != nil && g.ValidUntil != nil && !g.ValidUntil.After(*g.ValidFrom) {
+		return ErrInvalidValidity
+	}
+
 	return nil
 }
+
+// subjectType validates the subject type of a grant. Grants created before
+// subjects were introduced name roles only, so an empty type means a role.
+func subjectType(t string) (string, error) {
+	switch t {
+	case "":
+		return SubjectRole, nil
+	case SubjectRole, SubjectGroup, SubjectUser:
+		return t, nil
+	}
+
+	return "", ErrInvalidSubject
+}
+
+// effect validates the effect of a grant; grants allow unless told otherwise.
+func effect(e string) (string, error) {
+	switch e {
+	case "":
+		return EffectAllow, nil
+	case EffectAllow, EffectDeny:
+		return e, nil
+	}
+
+	return "", ErrInvalidEffect
+}
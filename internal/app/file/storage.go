@@ -3,26 +3,49 @@ package file
 import (
 	"context"
 	"io"
-
-	"github.com/minio/minio-go/v7"
 )
 
-type Client interface {
-	GetFile(context.Context, string) (*minio.Object, error)
-	UploadFile(context.Context, string, int64, io.Reader) error
-	RemoveFile(context.Context, string) error
-	RenameFile(context.Context, string, string) error
-	GetFiles(context.Context) ([]SubDir, error)
+// ObjectStore keeps the content of files. Names are full object names
+// including the "backend/" prefix; a directory is an empty object whose name
+// ends with a slash.
+type ObjectStore interface {
+	// GetObject opens an object for reading; the caller closes it.
+	GetObject(context.Context, string) (io.ReadSeekCloser, *ObjectInfo, error)
+	// PutObject stores an object; an empty contentType stores it as
+	// application/octet-stream.
+	PutObject(ctx context.Context, name string, size int64, contentType string, reader io.Reader) error
+	CopyObject(ctx context.Context, src, dst string) error
+	RemoveObject(context.Context, string) error
+	ListObjects(ctx context.Context, prefix string) ([]string, error)
+}
 
-	CreateDirectory(context.Context, string) error
-	RenameDirectory(context.Context, string, string) error
-	RemoveDirectory(context.Context, string) error
+// MetadataStore keeps what is known about the files: repositories, the nodes
+// in them and the grants on those nodes, along with repository
+// administrators and access requests. Paths are object paths as returned by
+// ObjectPath.
+type MetadataStore interface {
+	AddNode(context.Context, string) error
+	MoveNodes(ctx context.Context, old, new string) error
+	RemoveNode(context.Context, string) error
+	RemoveNodes(context.Context, string) error
 
-	CreateRepository(context.Context, string) error
+	AddRepository(context.Context, string) error
+	RepositoryExists(context.Context, string) (bool, error)
 	GetRepositories(context.Context) (*[]Repos, error)
 	GetRepositoryFiles(context.Context, string) (*[]RepoFiles, error)
 	GetRepositoryPerms(context.Context, string) (*[]RepoPermsId, error)
 	AddRepositoryPerms(context.Context, string, RepoPerms) error
 	EditRepositoryPerms(context.Context, string, RepoPermsId) error
 	RemoveRepositoryPerms(context.Context, string, RepoPerms) error
+	ApplyPermsChanges(context.Context, []string, PermsDiffFunc) ([]RepoPermsDiff, error)
+	RemoveExpiredPerms(context.Context) (int64, error)
+
+	CreateAccessRequest(context.Context, *AccessRequest) error
+	GetAccessRequests(context.Context, string) (*[]AccessRequest, error)
+	FindAccessRequest(context.Context, int) (*AccessRequest, error)
+	DecideAccessRequest(context.Context, *AccessRequest, *RepoPerms) error
+
+	GetRepositoryAdmins(context.Context, string) (*[]RepoAdmin, error)
+	AddRepositoryAdmin(context.Context, string, RepoAdmin) error
+	RemoveRepositoryAdmin(context.Context, string, RepoAdmin) error
 }
@@ -0,0 +1,353 @@
+package localfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	model "files_test_rus/internal/app/file"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tmpDir holds uploads until they are complete. It lives under the root so
+// that finished uploads can be renamed into place.
+const tmpDir = ".tmp"
+
+// typeDir holds the content type of every file object, in a file at the
+// same path below it as the object below the root.
+const typeDir = ".types"
+
+// dirMarker is the file that makes a directory a directory object. Writing a
+// file creates its parent directories too, but like in a bucket those are no
+// objects of their own.
+const dirMarker = ".dir"
+
+var ErrInvalidName = errors.New("object name must not be empty or contain ., .., or .dir segments")
+
+// Client keeps the content of files as plain files under a root directory.
+// An object name maps to the path of the same name below the root, and a
+// directory object, whose name ends with a slash, to a directory holding a
+// marker file.
+type Client struct {
+	logger *logrus.Logger
+	root   string
+}
+
+func NewClient(root string, logger *logrus.Logger) (*Client, error) {
+	if root == "" {
+		return nil, errors.New("localfs root directory is not set")
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, tmpDir), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create localfs root. err: %w", err)
+	}
+
+	return &Client{
+		logger: logger,
+		root:   filepath.Clean(root),
+	}, nil
+}
+
+func (c *Client) GetObject(ctx context.Context, name string) (io.ReadSeekCloser, *model.ObjectInfo, error) {
+	c.logger.Infof("DOWNLOAD A FILE %s", name)
+
+	p, err := c.path(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if stat.IsDir() {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s is a directory", name)
+	}
+
+	return f, &model.ObjectInfo{
+		Name:        name,
+		Size:        stat.Size(),
+		ContentType: c.contentType(name),
+		ETag:        fmt.Sprintf("%x-%x", stat.ModTime().UnixNano(), stat.Size()),
+		ModTime:     stat.ModTime(),
+	}, nil
+}
+
+// PutObject stores an object. The content is written to a temporary file
+// first, so readers never see a partial upload.
+func (c *Client) PutObject(ctx context.Context, name string, size int64, contentType string, reader io.Reader) error {
+	p, err := c.path(name)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(name, "/") {
+		return c.mkdir(p)
+	}
+
+	c.logger.Debugf("put new object %s to %s", name, c.root)
+	if err := c.setContentType(name, contentType); err != nil {
+		return fmt.Errorf("failed to upload file. err: %w", err)
+	}
+	if err := c.write(p, reader); err != nil {
+		return fmt.Errorf("failed to upload file. err: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) CopyObject(ctx context.Context, src, dst string) error {
+	srcPath, err := c.path(src)
+	if err != nil {
+		return err
+	}
+	dstPath, err := c.path(dst)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(src, "/") {
+		return c.mkdir(dstPath)
+	}
+
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to copy file. err: %w", err)
+	}
+	defer f.Close()
+
+	if err := c.setContentType(dst, c.contentType(src)); err != nil {
+		return fmt.Errorf("failed to copy file. err: %w", err)
+	}
+
+	if err := c.write(dstPath, f); err != nil {
+		return fmt.Errorf("failed to copy file. err: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveObject removes an object. Like in a bucket, removing an object that
+// does not exist is not an error. Removing a directory object removes its
+// marker; the directory itself goes once it is empty, which it is when
+// ListObjects was used to remove everything in it.
+func (c *Client) RemoveObject(ctx context.Context, name string) error {
+	p, err := c.path(name)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(name, "/") {
+		if err := os.Remove(filepath.Join(p, dirMarker)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete directory. err: %w", err)
+		}
+		c.prune(p)
+		return nil
+	}
+
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete file. err: %w", err)
+	}
+	c.prune(filepath.Dir(p))
+
+	if err := c.setContentType(name, ""); err != nil {
+		c.logger.Warnf("failed to delete content type of %s. err: %v", name, err)
+	}
+
+	return nil
+}
+
+// prune removes dir and the directories above it while they are empty and
+// no directory objects, as a bucket forgets a prefix with its last object.
+func (c *Client) prune(dir string) {
+	for len(dir) > len(c.root) && !isDirObject(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// ListObjects returns the names of all objects starting with prefix, in
+// name order except that a directory object is listed after everything in
+// it, so the names can be removed in the order they are returned.
+func (c *Client) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	// Only the directory holding the prefix can contain matching names.
+	start := c.root
+	if i := strings.LastIndexByte(prefix, '/'); i >= 0 {
+		dir, err := c.path(prefix[:i+1])
+		if err != nil {
+			return nil, err
+		}
+		start = dir
+	}
+
+	var names []string
+	var walk func(dir, key string) error
+	walk = func(dir, key string) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+		for _, e := range entries {
+			name := key + e.Name()
+			if name == tmpDir || name == typeDir || e.Name() == dirMarker {
+				continue
+			}
+
+			if !e.IsDir() {
+				if strings.HasPrefix(name, prefix) {
+					names = append(names, name)
+				}
+				continue
+			}
+
+			// Walk a directory only when names below it can match.
+			name += "/"
+			if strings.HasPrefix(name, prefix) || strings.HasPrefix(prefix, name) {
+				if err := walk(filepath.Join(dir, e.Name()), name); err != nil {
+					return err
+				}
+			}
+			if strings.HasPrefix(name, prefix) && isDirObject(filepath.Join(dir, e.Name())) {
+				names = append(names, name)
+			}
+		}
+
+		return nil
+	}
+
+	key, err := filepath.Rel(c.root, start)
+	if err != nil {
+		return nil, err
+	}
+	if key == "." {
+		key = ""
+	} else {
+		key = filepath.ToSlash(key) + "/"
+	}
+
+	if err := walk(start, key); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// The walk started inside the directory the prefix names, if any.
+	if key != "" && strings.HasPrefix(key, prefix) && isDirObject(start) {
+		names = append(names, key)
+	}
+
+	return names, nil
+}
+
+// path maps an object name to its path below the root. Names that could
+// leave the root are refused.
+func (c *Client) path(name string) (string, error) {
+	trimmed := strings.TrimSuffix(name, "/")
+	if trimmed == "" {
+		return "", ErrInvalidName
+	}
+
+	for _, part := range strings.Split(trimmed, "/") {
+		if part == "" || part == "." || part == ".." || part == dirMarker {
+			return "", ErrInvalidName
+		}
+	}
+
+	return filepath.Join(c.root, filepath.FromSlash(path.Clean(trimmed))), nil
+}
+
+// mkdir creates the directory object at p.
+func (c *Client) mkdir(p string) error {
+	if err := os.MkdirAll(p, 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(p, dirMarker), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// contentType returns the content type the file object name was stored
+// with.
+func (c *Client) contentType(name string) string {
+	b, err := os.ReadFile(filepath.Join(c.root, typeDir, filepath.FromSlash(name)))
+	if err != nil || len(b) == 0 {
+		return "application/octet-stream"
+	}
+
+	return string(b)
+}
+
+// setContentType records the content type of the file object name; an
+// empty one forgets it.
+func (c *Client) setContentType(name, contentType string) error {
+	p := filepath.Join(c.root, typeDir, filepath.FromSlash(name))
+	if contentType == "" {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		c.prune(filepath.Dir(p))
+		return nil
+	}
+
+	return c.write(p, strings.NewReader(contentType))
+}
+
+// isDirObject reports whether the directory at p is a directory object
+// rather than one that only holds objects.
+func isDirObject(p string) bool {
+	_, err := os.Stat(filepath.Join(p, dirMarker))
+
+	return err == nil
+}
+
+// write stores the content of r at p through a temporary file.
+func (c *Client) write(p string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(c.root, tmpDir), "upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	// CreateTemp makes the file readable by its owner only.
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), p)
+}
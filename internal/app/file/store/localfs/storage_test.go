@@ -0,0 +1,206 @@
+package localfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	c, err := NewClient(t.TempDir(), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestPath(t *testing.T) {
+	c := newTestClient(t)
+
+	for _, name := range []string{
+		"",
+		"/",
+		"..",
+		"../etc/passwd",
+		"backend/../../etc/passwd",
+		"backend/a/..",
+		".",
+		"./backend",
+		"backend/./a",
+		"backend//a",
+		"/etc/passwd",
+		"backend/a/.dir",
+	} {
+		if p, err := c.path(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("path(%q) = %q, %v, want %v", name, p, err, ErrInvalidName)
+		}
+	}
+
+	for name, want := range map[string]string{
+		"backend/a/b.txt": filepath.Join(c.root, "backend", "a", "b.txt"),
+		"backend/a/":      filepath.Join(c.root, "backend", "a"),
+		"backend/..a":     filepath.Join(c.root, "backend", "..a"),
+	} {
+		if p, err := c.path(name); err != nil || p != want {
+			t.Errorf("path(%q) = %q, %v, want %q", name, p, err, want)
+		}
+	}
+}
+
+func TestListObjects(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	for _, name := range []string{
+		"backend/r/",
+		"backend/r/b.txt",
+		"backend/r/a/",
+		"backend/r/a/x.txt",
+		"backend/r/implicit/y.txt",
+		"backend/rx.txt",
+	} {
+		if err := c.PutObject(ctx, name, 0, "", strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"backend/r/", []string{
+			"backend/r/a/x.txt",
+			"backend/r/a/",
+			"backend/r/b.txt",
+			"backend/r/implicit/y.txt",
+			"backend/r/",
+		}},
+		{"backend/r", []string{
+			"backend/r/a/x.txt",
+			"backend/r/a/",
+			"backend/r/b.txt",
+			"backend/r/implicit/y.txt",
+			"backend/r/",
+			"backend/rx.txt",
+		}},
+		{"backend/r/a", []string{
+			"backend/r/a/x.txt",
+			"backend/r/a/",
+		}},
+		{"backend/r/implicit/", []string{
+			"backend/r/implicit/y.txt",
+		}},
+		{"backend/missing/", nil},
+	}
+
+	for _, tt := range tests {
+		got, err := c.ListObjects(ctx, tt.prefix)
+		if err != nil {
+			t.Fatalf("ListObjects(%q): %v", tt.prefix, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ListObjects(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveObjects(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"backend/r/", "backend/r/implicit/y.txt"} {
+		if err := c.PutObject(ctx, name, 0, "", strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names, err := c.ListObjects(ctx, "backend/r/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if err := c.RemoveObject(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(c.root, "backend")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("backend directory left behind: %v", err)
+	}
+}
+
+func TestPutObjectMode(t *testing.T) {
+	c := newTestClient(t)
+
+	if err := c.PutObject(context.Background(), "backend/a.txt", 5, "text/plain", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := os.Stat(filepath.Join(c.root, "backend", "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := stat.Mode().Perm(); mode != 0o644 {
+		t.Errorf("mode = %o, want 644", mode)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	if err := c.PutObject(ctx, "backend/r/a.txt", 5, "text/plain", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutObject(ctx, "backend/r/b.bin", 3, "", strings.NewReader("bin")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CopyObject(ctx, "backend/r/a.txt", "backend/r/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"backend/r/a.txt": "text/plain",
+		"backend/r/b.bin": "application/octet-stream",
+		"backend/r/c.txt": "text/plain",
+	} {
+		f, info, err := c.GetObject(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		if info.ContentType != want {
+			t.Errorf("ContentType of %s = %q, want %q", name, info.ContentType, want)
+		}
+	}
+
+	names, err := c.ListObjects(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"backend/r/a.txt", "backend/r/b.bin", "backend/r/c.txt"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ListObjects = %q, want %q", names, want)
+	}
+
+	for _, name := range names {
+		if err := c.RemoveObject(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(c.root, typeDir)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("content types left behind: %v", err)
+	}
+}
@@ -0,0 +1,447 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	model "files_test_rus/internal/app/file"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// Authorizer evaluates the grants kept in the grants table. The nearest path
+// with an allow grant for any subject of the principal decides, minus
+// whatever is denied on that path or below it. The "admin" role may do
+// anything. Owners and managers of a repository may manage it on top of
+// what their grants allow; for the files in it the grants decide.
+type Authorizer struct {
+	db     *sql.DB
+	logger *logrus.Logger
+}
+
+func NewAuthorizer(db *sql.DB, logger *logrus.Logger) *Authorizer {
+	return &Authorizer{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (a *Authorizer) Can(ctx context.Context, principal model.Principal, action model.Permission, path string) (bool, error) {
+	e, err := a.Explain(ctx, principal, action, path)
+	if err != nil {
+		return false, err
+	}
+
+	return e.Allowed, nil
+}
+
+// Explain evaluates the same rules as Can and records every path of the
+// ancestor walk together with the grants found on it.
+func (a *Authorizer) Explain(ctx context.Context, principal model.Principal, action model.Permission, path string) (*model.Explanation, error) {
+	e := &model.Explanation{
+		Path:   path,
+		Action: action.String(),
+		Steps:  []model.ExplainStep{},
+	}
+
+	ev, err := a.newEvaluator(ctx, principal)
+	if err != nil {
+		return nil, err
+	}
+
+	permission, err := ev.walk(ctx, path, e)
+	if err != nil {
+		return nil, err
+	}
+
+	e.Allowed = permission.Has(action)
+	if e.Allowed && levelPermission(e.Level).Has(action) {
+		e.Reason = fmt.Sprintf("%s of repository %s", e.Level, strings.Split(path, "/")[0])
+	} else if e.Reason == "" {
+		// An allow grant decided; it is on the last path the walk checked.
+		decided := e.Steps[len(e.Steps)-1].Path
+		switch {
+		case e.Allowed:
+			e.Reason = fmt.Sprintf("granted by %s", decided)
+		case (permission | deniedOn(e.Steps)).Has(action):
+			e.Reason = fmt.Sprintf("granted by %s but denied on the way there", decided)
+		default:
+			e.Reason = fmt.Sprintf("the grants on %s do not include %s", decided, action)
+		}
+	}
+
+	return e, nil
+}
+
+// Effective returns every capability the principal has on path.
+func (a *Authorizer) Effective(ctx context.Context, principal model.Principal, path string) (model.Permission, error) {
+	ev, err := a.newEvaluator(ctx, principal)
+	if err != nil {
+		return 0, err
+	}
+
+	return ev.walk(ctx, path, &model.Explanation{})
+}
+
+func (a *Authorizer) EffectiveAll(ctx context.Context, principal model.Principal, paths []string) ([]model.Permission, error) {
+	ev, err := a.newEvaluator(ctx, principal)
+	if err != nil {
+		return nil, err
+	}
+
+	perms := make([]model.Permission, len(paths))
+	for i, path := range paths {
+		if perms[i], err = ev.walk(ctx, path, &model.Explanation{}); err != nil {
+			return nil, err
+		}
+	}
+
+	return perms, nil
+}
+
+// evaluator resolves the permissions of one principal. What it needs to
+// know about a repository is loaded on first use and kept, so that any
+// number of paths costs a few queries per repository.
+type evaluator struct {
+	a     *Authorizer
+	roles []string
+	sub   subjects
+	now   time.Time
+	repos map[string]*repoGrants
+}
+
+// repoGrants is what the evaluator knows about a repository: the level at
+// which the principal administers it and every grant in it, by path.
+type repoGrants struct {
+	exists bool
+	level  string
+	grants map[string][]model.RepoPermsId
+}
+
+func (a *Authorizer) newEvaluator(ctx context.Context, principal model.Principal) (*evaluator, error) {
+	roles, err := a.roleChain(ctx, principal)
+	if err != nil {
+		return nil, err
+	}
+
+	return &evaluator{
+		a:     a,
+		roles: roles,
+		sub:   newSubjects(principal, roles),
+		now:   time.Now(),
+		repos: make(map[string]*repoGrants),
+	}, nil
+}
+
+func (ev *evaluator) repository(ctx context.Context, name string) (*repoGrants, error) {
+	if repo, ok := ev.repos[name]; ok {
+		return repo, nil
+	}
+
+	repo := &repoGrants{}
+	if err := ev.a.db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM repositories WHERE repo = $1)",
+		name,
+	).Scan(&repo.exists); err != nil {
+		return nil, err
+	}
+
+	if repo.exists {
+		var err error
+		if repo.level, err = ev.a.adminLevel(ctx, name, ev.sub); err != nil {
+			return nil, err
+		}
+		if repo.grants, err = ev.a.grants(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+	ev.repos[name] = repo
+
+	return repo, nil
+}
+
+// walk resolves the permission of the principal on path, loading what it
+// needs to know about the repository first. Every step is recorded in e,
+// together with the reason when the walk ends without a grant deciding.
+func (ev *evaluator) walk(ctx context.Context, path string, e *model.Explanation) (model.Permission, error) {
+	title := ""
+	if len(ev.roles) > 0 {
+		title = ev.roles[0]
+		e.Role = title
+		e.InheritedRoles = ev.roles[1:]
+	}
+
+	if title == "admin" {
+		e.Reason = "the admin role may do anything"
+		return model.PermAll, nil
+	}
+
+	if path == "" {
+		e.Reason = "only the admin role may act on the whole store"
+		return 0, nil
+	}
+
+	repName := strings.Split(path, "/")[0]
+
+	repo, err := ev.repository(ctx, repName)
+	if err != nil {
+		return 0, err
+	}
+	if !repo.exists {
+		e.Reason = fmt.Sprintf("repository %s does not exist", repName)
+		return 0, nil
+	}
+
+	return ev.evaluate(path, repo, e), nil
+}
+
+// evaluate resolves the permission of the principal on path from the grants
+// of its repository. Only grants for one of its subjects that are valid at
+// the time the evaluator was created count. The nearest path with an allow
+// grant decides; deny grants on that path or nearer ones are taken away from
+// it.
+func (ev *evaluator) evaluate(path string, repo *repoGrants, e *model.Explanation) model.Permission {
+	e.Level = repo.level
+	permission := levelPermission(repo.level)
+
+	var denied model.Permission
+	for name := path; name != ""; name = parent(name) {
+		grants := []model.RepoPermsId{}
+		for _, g := range repo.grants[name] {
+			if ev.sub.match(g) && valid(g, ev.now) {
+				grants = append(grants, g)
+			}
+		}
+
+		step := model.ExplainStep{Path: name, Grants: grants}
+		if len(grants) == 0 {
+			e.Steps = append(e.Steps, step)
+			continue
+		}
+
+		var allowed, deny model.Permission
+		hasAllow := false
+		for _, g := range grants {
+			perm, err := model.ParsePermission(g.Permission)
+			if err != nil {
+				ev.a.logger.Warnf("invalid permission %q on %s", g.Permission, name)
+				continue
+			}
+
+			if g.Effect == model.EffectDeny {
+				deny |= perm
+			} else {
+				allowed |= perm
+				hasAllow = true
+			}
+		}
+		step.Permission = allowed.String()
+		step.Denied = deny.String()
+		e.Steps = append(e.Steps, step)
+
+		denied |= deny
+		if hasAllow {
+			return permission | allowed&^denied
+		}
+	}
+
+	e.Reason = "no allow grant found on the path or any of its ancestors"
+	return permission
+}
+
+// valid reports whether g is in force at now.
+func valid(g model.RepoPermsId, now time.Time) bool {
+	return (g.ValidFrom == nil || !g.ValidFrom.After(now)) &&
+		(g.ValidUntil == nil || g.ValidUntil.After(now))
+}
+
+// levelPermission returns what administering a repository at level allows
+// regardless of the grants: managing it and handing its paths out.
+func levelPermission(level string) model.Permission {
+	switch level {
+	case model.LevelOwner, model.LevelManager:
+		return model.PermManage | model.PermShare
+	}
+
+	return 0
+}
+
+func (a *Authorizer) AdminLevel(ctx context.Context, principal model.Principal, repo string) (string, error) {
+	roles, err := a.roleChain(ctx, principal)
+	if err != nil {
+		return "", err
+	}
+
+	if len(roles) > 0 && roles[0] == "admin" {
+		return model.LevelAdmin, nil
+	}
+
+	return a.adminLevel(ctx, repo, newSubjects(principal, roles))
+}
+
+// adminLevel returns the highest level at which any of the subjects
+// administers repo, or "" when none of them does.
+func (a *Authorizer) adminLevel(ctx context.Context, repo string, sub subjects) (string, error) {
+	rows, err := a.db.QueryContext(ctx, `SELECT ra.level FROM repository_admins ra
+		JOIN repositories r ON r.id = ra.repo_id
+		WHERE r.repo = $1 AND (
+			(ra.subject_type = 'role' AND ra.subject = ANY($2))
+			OR (ra.subject_type = 'group' AND ra.subject = ANY($3))
+			OR (ra.subject_type = 'user' AND ra.subject = ANY($4)))`,
+		repo, pq.Array(sub.roles), pq.Array(sub.groups), pq.Array(sub.user))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	level := ""
+	for rows.Next() {
+		var l string
+		if err := rows.Scan(&l); err != nil {
+			return "", err
+		}
+		if l == model.LevelOwner || level == "" {
+			level = l
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	return level, nil
+}
+
+// maxRoleDepth bounds the walk up the role parents; cycles are refused when
+// parents are set, this only guards against rows edited by hand.
+const maxRoleDepth = 32
+
+// roleChain returns the title of the role of the principal followed by the
+// titles of its ancestors, nearest first.
+func (a *Authorizer) roleChain(ctx context.Context, principal model.Principal) ([]string, error) {
+	if principal.RoleId == "" {
+		return nil, nil
+	}
+
+	rows, err := a.db.QueryContext(ctx, `WITH RECURSIVE chain AS (
+			SELECT id, title, parent_id, 0 AS depth FROM roles WHERE id = $1
+			UNION ALL
+			SELECT r.id, r.title, r.parent_id, c.depth + 1 FROM roles r
+			JOIN chain c ON r.id = c.parent_id
+			WHERE c.depth < $2
+		)
+		SELECT title FROM chain ORDER BY depth`,
+		principal.RoleId, maxRoleDepth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			return nil, err
+		}
+		roles = append(roles, title)
+	}
+
+	return roles, rows.Err()
+}
+
+// subjects describes everyone the principal acts as in the grants table: its
+// role and the roles it inherits from, its groups and the user itself, known
+// by id as well as email.
+type subjects struct {
+	roles  []string
+	groups []string
+	user   []string
+}
+
+func newSubjects(principal model.Principal, roles []string) subjects {
+	sub := subjects{roles: roles, groups: principal.Groups}
+
+	for _, v := range []string{principal.UserId, principal.Email} {
+		if v != "" {
+			sub.user = append(sub.user, v)
+		}
+	}
+
+	return sub
+}
+
+// match reports whether g is given to any of the subjects.
+func (sub subjects) match(g model.RepoPermsId) bool {
+	var names []string
+	switch g.SubjectType {
+	case model.SubjectRole:
+		names = sub.roles
+	case model.SubjectGroup:
+		names = sub.groups
+	case model.SubjectUser:
+		names = sub.user
+	}
+
+	for _, name := range names {
+		if name == g.RoleTitle {
+			return true
+		}
+	}
+
+	return false
+}
+
+// grants returns every grant in repName by path; which of them apply is up
+// to the evaluator.
+func (a *Authorizer) grants(ctx context.Context, repName string) (map[string][]model.RepoPermsId, error) {
+	rows, err := a.db.QueryContext(ctx, `SELECT g.id, g.subject_type, g.subject, g.path, g.permission, g.effect, g.valid_from, g.valid_until
+		FROM grants g JOIN repositories r ON r.id = g.repository_id
+		WHERE r.repo = $1
+		ORDER BY g.id`,
+		repName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := map[string][]model.RepoPermsId{}
+	for rows.Next() {
+		var g model.RepoPermsId
+		if err := rows.Scan(&g.Id, &g.SubjectType, &g.RoleTitle, &g.Path, &g.Permission, &g.Effect, &g.ValidFrom, &g.ValidUntil); err != nil {
+			return nil, err
+		}
+		grants[g.Path] = append(grants[g.Path], g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+// deniedOn collects the capabilities denied along the recorded steps.
+func deniedOn(steps []model.ExplainStep) model.Permission {
+	var denied model.Permission
+	for _, step := range steps {
+		if step.Denied == "" {
+			continue
+		}
+		if perm, err := model.ParsePermission(step.Denied); err == nil {
+			denied |= perm
+		}
+	}
+
+	return denied
+}
+
+// parent returns the path one level up, or "" above the repository root.
+func parent(path string) string {
+	if i := strings.LastIndexByte(path, '/'); i >= 0 {
+		return path[:i]
+	}
+
+	return ""
+}
@@ -0,0 +1,179 @@
+package postgres
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	model "files_test_rus/internal/app/file"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestEvaluate(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	allow := func(subjectType, subject, path, permission string) model.RepoPermsId {
+		return model.RepoPermsId{SubjectType: subjectType, RoleTitle: subject, Path: path, Permission: permission, Effect: model.EffectAllow}
+	}
+	deny := func(subjectType, subject, path, permission string) model.RepoPermsId {
+		g := allow(subjectType, subject, path, permission)
+		g.Effect = model.EffectDeny
+		return g
+	}
+	until := func(g model.RepoPermsId, t time.Time) model.RepoPermsId {
+		g.ValidUntil = &t
+		return g
+	}
+	from := func(g model.RepoPermsId, t time.Time) model.RepoPermsId {
+		g.ValidFrom = &t
+		return g
+	}
+
+	tests := []struct {
+		name   string
+		level  string
+		grants []model.RepoPermsId
+		path   string
+		want   string
+	}{
+		{
+			name: "no grants",
+			path: "r/a/b.txt",
+			want: "",
+		},
+		{
+			name: "nearest allow wins",
+			grants: []model.RepoPermsId{
+				allow(model.SubjectRole, "dev", "r", "list,read,write"),
+				allow(model.SubjectRole, "dev", "r/a", "list"),
+			},
+			path: "r/a/b.txt",
+			want: "list",
+		},
+		{
+			name: "allow on an ancestor",
+			grants: []model.RepoPermsId{
+				allow(model.SubjectRole, "dev", "r", "list,read"),
+			},
+			path: "r/a/b.txt",
+			want: "list,read",
+		},
+		{
+			name: "deny overrides allow on the same path",
+			grants: []model.RepoPermsId{
+				allow(model.SubjectRole, "dev", "r/a", "list,read,write"),
+				deny(model.SubjectUser, "7", "r/a", "write"),
+			},
+			path: "r/a/b.txt",
+			want: "list,read",
+		},
+		{
+			name: "deny on a nearer path",
+			grants: []model.RepoPermsId{
+				allow(model.SubjectRole, "dev", "r", "list,read,write"),
+				deny(model.SubjectGroup, "contractors", "r/a", "read"),
+			},
+			path: "r/a/b.txt",
+			want: "list,write",
+		},
+		{
+			name: "deny above the deciding allow is ignored",
+			grants: []model.RepoPermsId{
+				deny(model.SubjectRole, "dev", "r", "read"),
+				allow(model.SubjectRole, "dev", "r/a", "list,read"),
+			},
+			path: "r/a/b.txt",
+			want: "list,read",
+		},
+		{
+			name: "expired allow",
+			grants: []model.RepoPermsId{
+				allow(model.SubjectRole, "dev", "r", "list"),
+				until(allow(model.SubjectRole, "dev", "r/a", "list,read"), past),
+			},
+			path: "r/a/b.txt",
+			want: "list",
+		},
+		{
+			name: "allow not yet valid",
+			grants: []model.RepoPermsId{
+				from(allow(model.SubjectUser, "dev@example.com", "r/a", "list,read"), future),
+			},
+			path: "r/a/b.txt",
+			want: "",
+		},
+		{
+			name: "expired deny",
+			grants: []model.RepoPermsId{
+				allow(model.SubjectRole, "dev", "r/a", "list,read"),
+				until(deny(model.SubjectRole, "dev", "r/a", "read"), now),
+			},
+			path: "r/a/b.txt",
+			want: "list,read",
+		},
+		{
+			name: "grant valid within its window",
+			grants: []model.RepoPermsId{
+				until(from(allow(model.SubjectRole, "dev", "r/a", "read"), past), future),
+			},
+			path: "r/a/b.txt",
+			want: "read",
+		},
+		{
+			name: "inherited role",
+			grants: []model.RepoPermsId{
+				allow(model.SubjectRole, "staff", "r/a", "list,read"),
+			},
+			path: "r/a/b.txt",
+			want: "list,read",
+		},
+		{
+			name: "other subjects",
+			grants: []model.RepoPermsId{
+				allow(model.SubjectRole, "ops", "r", "list,read"),
+				allow(model.SubjectGroup, "dev", "r", "list,read"),
+				allow(model.SubjectUser, "8", "r", "list,read"),
+			},
+			path: "r/a/b.txt",
+			want: "",
+		},
+		{
+			name:  "manager",
+			level: model.LevelManager,
+			grants: []model.RepoPermsId{
+				allow(model.SubjectRole, "dev", "r", "list"),
+			},
+			path: "r/a/b.txt",
+			want: "list,share,manage",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := &evaluator{
+				a:     &Authorizer{logger: logger},
+				roles: []string{"dev", "staff"},
+				sub: newSubjects(model.Principal{
+					UserId: "7",
+					Email:  "dev@example.com",
+					Groups: []string{"contractors"},
+				}, []string{"dev", "staff"}),
+				now: now,
+			}
+
+			repo := &repoGrants{exists: true, level: tt.level, grants: map[string][]model.RepoPermsId{}}
+			for _, g := range tt.grants {
+				repo.grants[g.Path] = append(repo.grants[g.Path], g)
+			}
+
+			if got := ev.evaluate(tt.path, repo, &model.Explanation{}).String(); got != tt.want {
+				t.Errorf("evaluate(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,555 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	model "files_test_rus/internal/app/file"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Metadata keeps what is known about the objects of the store in the
+// database: repositories, their nodes and grants, administrators and access
+// requests. Every statement takes its values as parameters.
+type Metadata struct {
+	db     *sql.DB
+	logger *logrus.Logger
+}
+
+func NewMetadata(db *sql.DB, logger *logrus.Logger) *Metadata {
+	return &Metadata{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (m *Metadata) GetRepositories(ctx context.Context) (*[]model.Repos, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT id, repo FROM repositories ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reposList []model.Repos
+
+	for rows.Next() {
+		var reposListEl model.Repos
+		if err := rows.Scan(&reposListEl.Id, &reposListEl.Name); err != nil {
+			return &reposList, err
+		}
+		reposList = append(reposList, reposListEl)
+	}
+
+	if err = rows.Err(); err != nil {
+		return &reposList, err
+	}
+
+	return &reposList, err
+}
+
+func (m *Metadata) RemoveRepositoryPerms(ctx context.Context, repoName string, rp model.RepoPerms) error {
+	_, err := m.db.ExecContext(ctx, `DELETE FROM grants g USING repositories r
+		WHERE r.id = g.repository_id AND r.repo = $1
+		AND g.subject_type = $2 AND g.subject = $3 AND g.path = $4 AND g.permission = $5 AND g.effect = $6`,
+		repoName, rp.SubjectType, rp.RoleTitle, rp.Path, rp.Permission, rp.Effect)
+
+	return err
+}
+
+func (m *Metadata) EditRepositoryPerms(ctx context.Context, repoName string, rp model.RepoPermsId) error {
+	_, err := m.db.ExecContext(ctx, `UPDATE grants g SET subject_type = $1, subject = $2, path = $3, permission = $4,
+		effect = $5, valid_from = $6, valid_until = $7
+		FROM repositories r WHERE r.id = g.repository_id AND r.repo = $8 AND g.id = $9`,
+		rp.SubjectType, rp.RoleTitle, rp.Path, rp.Permission, rp.Effect, rp.ValidFrom, rp.ValidUntil, repoName, rp.Id)
+
+	return err
+}
+
+func (m *Metadata) GetRepositoryAdmins(ctx context.Context, repoName string) (*[]model.RepoAdmin, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT ra.subject_type, ra.subject, ra.level FROM repository_admins ra
+		JOIN repositories r ON r.id = ra.repo_id
+		WHERE r.repo = $1 ORDER BY ra.level, ra.subject`, repoName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	admins := []model.RepoAdmin{}
+	for rows.Next() {
+		var admin model.RepoAdmin
+		if err := rows.Scan(&admin.SubjectType, &admin.Subject, &admin.Level); err != nil {
+			return nil, err
+		}
+		admins = append(admins, admin)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &admins, nil
+}
+
+// AddRepositoryAdmin appoints an administrator of repoName, or changes the
+// level of an existing one.
+func (m *Metadata) AddRepositoryAdmin(ctx context.Context, repoName string, admin model.RepoAdmin) error {
+	res, err := m.db.ExecContext(ctx, `INSERT INTO repository_admins (repo_id, subject_type, subject, level)
+		SELECT id, $2, $3, $4 FROM repositories WHERE repo = $1
+		ON CONFLICT (repo_id, subject_type, subject) DO UPDATE SET level = EXCLUDED.level`,
+		repoName, admin.SubjectType, admin.Subject, admin.Level)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("repository %s not found", repoName)
+	}
+
+	return nil
+}
+
+func (m *Metadata) RemoveRepositoryAdmin(ctx context.Context, repoName string, admin model.RepoAdmin) error {
+	_, err := m.db.ExecContext(ctx, `DELETE FROM repository_admins ra USING repositories r
+		WHERE r.id = ra.repo_id AND r.repo = $1 AND ra.subject_type = $2 AND ra.subject = $3`,
+		repoName, admin.SubjectType, admin.Subject)
+
+	return err
+}
+
+func (m *Metadata) CreateAccessRequest(ctx context.Context, ar *model.AccessRequest) error {
+	return m.db.QueryRowContext(ctx, `INSERT INTO access_requests (user_id, email, repo, path, permission, justification, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`,
+		ar.UserId, ar.Email, ar.Repo, ar.Path, ar.Permission, ar.Justification, ar.Status,
+	).Scan(&ar.Id, &ar.CreatedAt)
+}
+
+const accessRequestColumns = "id, user_id, email, repo, path, permission, justification, status, created_at, decided_by, decided_at, note"
+
+// GetAccessRequests lists the requests with the given status, oldest first;
+// an empty status lists all of them.
+func (m *Metadata) GetAccessRequests(ctx context.Context, status string) (*[]model.AccessRequest, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT "+accessRequestColumns+` FROM access_requests
+		WHERE $1 = '' OR status = $1 ORDER BY created_at, id`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []model.AccessRequest{}
+	for rows.Next() {
+		ar, err := scanAccessRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *ar)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &requests, nil
+}
+
+func (m *Metadata) FindAccessRequest(ctx context.Context, id int) (*model.AccessRequest, error) {
+	row := m.db.QueryRowContext(ctx, "SELECT "+accessRequestColumns+" FROM access_requests WHERE id = $1", id)
+
+	ar, err := scanAccessRequest(row)
+	if err == sql.ErrNoRows {
+		return nil, model.ErrRequestNotFound
+	}
+
+	return ar, err
+}
+
+func scanAccessRequest(row interface{ Scan(...any) error }) (*model.AccessRequest, error) {
+	var (
+		ar        model.AccessRequest
+		decidedBy sql.NullInt64
+	)
+	if err := row.Scan(&ar.Id, &ar.UserId, &ar.Email, &ar.Repo, &ar.Path, &ar.Permission, &ar.Justification,
+		&ar.Status, &ar.CreatedAt, &decidedBy, &ar.DecidedAt, &ar.Note); err != nil {
+		return nil, err
+	}
+	if decidedBy.Valid {
+		id := int(decidedBy.Int64)
+		ar.DecidedBy = &id
+	}
+
+	return &ar, nil
+}
+
+// DecideAccessRequest records the decision on a pending request. When grant
+// is set it is added to the grants of the repository, and the decision is
+// written to the audit log, all in a single transaction. The audit log only
+// covers these decisions; grants changed through the perms endpoints or an
+// import are not recorded there.
+func (m *Metadata) DecideAccessRequest(ctx context.Context, ar *model.AccessRequest, grant *model.RepoPerms) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRowContext(ctx, `UPDATE access_requests SET status = $1, decided_by = $2, decided_at = now(), note = $3
+		WHERE id = $4 AND status = $5 RETURNING decided_at`,
+		ar.Status, ar.DecidedBy, ar.Note, ar.Id, model.RequestPending,
+	).Scan(&ar.DecidedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return model.ErrRequestDecided
+		}
+		return err
+	}
+
+	if grant != nil {
+		repoId, err := repositoryId(ctx, tx, ar.Repo)
+		if err != nil {
+			return err
+		}
+
+		if err := insertGrant(ctx, tx, repoId, *grant); err != nil {
+			return err
+		}
+	}
+
+	actor := ""
+	if ar.DecidedBy != nil {
+		actor = strconv.Itoa(*ar.DecidedBy)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO perms_audit (actor, action, repo, subject_type, subject, path, permission, access_request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		actor, ar.Status, ar.Repo, model.SubjectUser, strconv.Itoa(ar.UserId), ar.Path, ar.Permission, ar.Id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ApplyPermsChanges applies an import in a single transaction. The grants of
+// every repository are locked before diff computes the changes to them, so
+// that nothing changes them in between.
+func (m *Metadata) ApplyPermsChanges(ctx context.Context, repos []string, diff model.PermsDiffFunc) ([]model.RepoPermsDiff, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	diffs := []model.RepoPermsDiff{}
+	for _, repo := range repos {
+		// Locking the repository row keeps grants from being added to it as
+		// well, since inserting one takes a key share lock on it.
+		var repoId int
+		if err := tx.QueryRowContext(ctx, "SELECT id FROM repositories WHERE repo = $1 FOR UPDATE", repo).Scan(&repoId); err != nil {
+			if err == sql.ErrNoRows {
+				return nil, fmt.Errorf("repository %s not found", repo)
+			}
+			return nil, err
+		}
+
+		current, err := lockGrants(ctx, tx, repoId)
+		if err != nil {
+			return nil, err
+		}
+
+		d, err := diff(repo, current)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rp := range d.Removed {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM grants WHERE id = $1 AND repository_id = $2", rp.Id, repoId); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, ch := range d.Changed {
+			if _, err := tx.ExecContext(ctx,
+				"UPDATE grants SET permission = $1, valid_from = $2, valid_until = $3 WHERE id = $4 AND repository_id = $5",
+				ch.New.Permission, ch.New.ValidFrom, ch.New.ValidUntil, ch.Old.Id, repoId); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, rp := range d.Added {
+			if err := insertGrant(ctx, tx, repoId, rp); err != nil {
+				return nil, err
+			}
+		}
+
+		diffs = append(diffs, *d)
+	}
+
+	return diffs, tx.Commit()
+}
+
+// lockGrants returns the grants of the repository and locks them until tx
+// ends.
+func lockGrants(ctx context.Context, tx *sql.Tx, repoId int) ([]model.RepoPermsId, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, subject_type, subject, path, permission, effect, valid_from, valid_until
+		FROM grants WHERE repository_id = $1 ORDER BY id FOR UPDATE`, repoId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []model.RepoPermsId
+	for rows.Next() {
+		var g model.RepoPermsId
+		if err := rows.Scan(&g.Id, &g.SubjectType, &g.RoleTitle, &g.Path, &g.Permission, &g.Effect, &g.ValidFrom, &g.ValidUntil); err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+
+	return grants, rows.Err()
+}
+
+// querier is what the helpers below need from either a *sql.DB or a *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func repositoryId(ctx context.Context, q querier, repoName string) (int, error) {
+	var id int
+	if err := q.QueryRowContext(ctx, "SELECT id FROM repositories WHERE repo = $1", repoName).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("repository %s not found", repoName)
+		}
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func insertGrant(ctx context.Context, q querier, repoId int, rp model.RepoPerms) error {
+	_, err := q.ExecContext(ctx, `INSERT INTO grants (repository_id, subject_type, subject, path, permission, effect, valid_from, valid_until)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		repoId, rp.SubjectType, rp.RoleTitle, rp.Path, rp.Permission, rp.Effect, rp.ValidFrom, rp.ValidUntil)
+
+	return err
+}
+
+// AddNode records an object in the metadata of the repository it belongs
+// to. Recording it twice is not an error.
+func (m *Metadata) AddNode(ctx context.Context, name string) error {
+	_, err := m.db.ExecContext(ctx, `INSERT INTO nodes (repository_id, path)
+		SELECT id, $2 FROM repositories WHERE repo = $1
+		ON CONFLICT (repository_id, path) DO NOTHING`,
+		repository(name), name)
+
+	return err
+}
+
+// MoveNodes moves the node at old and every node below it to new, possibly
+// into another repository. The grants on them move along within the
+// repository; they are given by its administrators, so a move into another
+// one drops them. Nodes already at new or below it are never merged.
+func (m *Metadata) MoveNodes(ctx context.Context, old, new string) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	oldId, err := repositoryId(ctx, tx, repository(old))
+	if err != nil {
+		return err
+	}
+	newId, err := repositoryId(ctx, tx, repository(new))
+	if err != nil {
+		return err
+	}
+
+	var taken bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM nodes
+		WHERE repository_id = $1 AND (path = $2 OR left(path, length($2) + 1) = $2 || '/'))`,
+		newId, new).Scan(&taken); err != nil {
+		return err
+	}
+	if taken {
+		return model.ErrDestinationTaken
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE nodes SET repository_id = $1, path = $2 || substr(path, length($3) + 1)
+		WHERE repository_id = $4 AND (path = $3 OR left(path, length($3) + 1) = $3 || '/')`,
+		newId, new, old, oldId); err != nil {
+		return err
+	}
+
+	if oldId != newId {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM grants
+			WHERE repository_id = $1 AND (path = $2 OR left(path, length($2) + 1) = $2 || '/')`,
+			oldId, old); err != nil {
+			return err
+		}
+	} else if _, err := tx.ExecContext(ctx, `UPDATE grants SET path = $1 || substr(path, length($2) + 1)
+		WHERE repository_id = $3 AND (path = $2 OR left(path, length($2) + 1) = $2 || '/')`,
+		new, old, oldId); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveNode forgets the node at name and the grants on exactly that path.
+func (m *Metadata) RemoveNode(ctx context.Context, name string) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	repoId, err := repositoryId(ctx, tx, repository(name))
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM nodes WHERE repository_id = $1 AND path = $2", repoId, name); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM grants WHERE repository_id = $1 AND path = $2", repoId, name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveNodes forgets the node at name and every node below it, together
+// with the grants on them.
+func (m *Metadata) RemoveNodes(ctx context.Context, name string) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	repoId, err := repositoryId(ctx, tx, repository(name))
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM nodes
+		WHERE repository_id = $1 AND (path = $2 OR left(path, length($2) + 1) = $2 || '/')`,
+		repoId, name); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM grants
+		WHERE repository_id = $1 AND (path = $2 OR left(path, length($2) + 1) = $2 || '/')`,
+		repoId, name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// repository returns the name of the repository a path belongs to.
+func repository(path string) string {
+	return strings.Split(path, "/")[0]
+}
+
+// RemoveExpiredPerms deletes the grants whose validity has ended and returns
+// how many were removed.
+func (m *Metadata) RemoveExpiredPerms(ctx context.Context) (int64, error) {
+	res, err := m.db.ExecContext(ctx, "DELETE FROM grants WHERE valid_until <= now()")
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// AddRepository records a new repository together with the node of its root.
+func (m *Metadata) AddRepository(ctx context.Context, name string) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var repoId int
+	if err := tx.QueryRowContext(ctx, "INSERT INTO repositories (repo) VALUES ($1) RETURNING id", name).Scan(&repoId); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO nodes (repository_id, path) VALUES ($1, $2)", repoId, name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *Metadata) RepositoryExists(ctx context.Context, name string) (bool, error) {
+	var exists bool
+	err := m.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM repositories WHERE repo = $1)", name).Scan(&exists)
+
+	return exists, err
+}
+
+func (m *Metadata) GetRepositoryFiles(ctx context.Context, repoName string) (*[]model.RepoFiles, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT n.id, n.path FROM nodes n
+		JOIN repositories r ON r.id = n.repository_id
+		WHERE r.repo = $1 ORDER BY n.path`, repoName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var filesList []model.RepoFiles
+
+	for rows.Next() {
+		var filesListEl model.RepoFiles
+		if err := rows.Scan(&filesListEl.Id, &filesListEl.Name); err != nil {
+			return &filesList, err
+		}
+
+		filesList = append(filesList, filesListEl)
+	}
+
+	if err = rows.Err(); err != nil {
+		return &filesList, err
+	}
+
+	return &filesList, err
+}
+
+func (m *Metadata) AddRepositoryPerms(ctx context.Context, repoName string, repoPerm model.RepoPerms) error {
+	repoId, err := repositoryId(ctx, m.db, repoName)
+	if err != nil {
+		return err
+	}
+
+	return insertGrant(ctx, m.db, repoId, repoPerm)
+}
+
+func (m *Metadata) GetRepositoryPerms(ctx context.Context, repoName string) (*[]model.RepoPermsId, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT g.id, g.subject_type, g.subject, g.path, g.permission, g.effect, g.valid_from, g.valid_until
+		FROM grants g JOIN repositories r ON r.id = g.repository_id
+		WHERE r.repo = $1 ORDER BY g.id`, repoName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permsList []model.RepoPermsId
+
+	for rows.Next() {
+		var permsListEl model.RepoPermsId
+		if err := rows.Scan(&permsListEl.Id, &permsListEl.SubjectType, &permsListEl.RoleTitle, &permsListEl.Path, &permsListEl.Permission, &permsListEl.Effect, &permsListEl.ValidFrom, &permsListEl.ValidUntil); err != nil {
+			return &permsList, err
+		}
+
+		permsList = append(permsList, permsListEl)
+	}
+
+	if err = rows.Err(); err != nil {
+		return &permsList, err
+	}
+
+	return &permsList, err
+}
@@ -0,0 +1,210 @@
+package file
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var ErrDuplicateGrant = errors.New("the same grant is listed twice")
+
+// RepoGrants is every grant of one repository, as exported and imported.
+type RepoGrants struct {
+	Repo   string      `json:"repo"`
+	Grants []RepoPerms `json:"grants"`
+}
+
+// RepoPermsChange is a grant whose permission or validity changes.
+type RepoPermsChange struct {
+	Old RepoPermsId `json:"old"`
+	New RepoPerms   `json:"new"`
+}
+
+// RepoPermsDiff lists what an import changes in one repository.
+type RepoPermsDiff struct {
+	Repo    string            `json:"repo"`
+	Added   []RepoPerms       `json:"added"`
+	Removed []RepoPermsId     `json:"removed"`
+	Changed []RepoPermsChange `json:"changed"`
+}
+
+// PermsDiffFunc computes what an import changes in repo given its current
+// grants. ApplyPermsChanges calls it with the grants locked, so the diff it
+// returns is applied exactly as computed.
+type PermsDiffFunc func(repo string, current []RepoPermsId) (*RepoPermsDiff, error)
+
+var csvHeader = []string{"repo", "subject_type", "role_title", "path", "permission", "effect", "valid_from", "valid_until"}
+
+// WriteGrantsCSV writes grants as CSV with one grant per row.
+func WriteGrantsCSV(w io.Writer, grants []RepoGrants) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, repo := range grants {
+		for _, g := range repo.Grants {
+			if err := cw.Write([]string{
+				repo.Repo, g.SubjectType, g.RoleTitle, g.Path, g.Permission, g.Effect,
+				formatTime(g.ValidFrom), formatTime(g.ValidUntil),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadGrantsCSV reads grants written by WriteGrantsCSV. Rows of the same
+// repository are grouped in the order the repositories first appear.
+func ReadGrantsCSV(r io.Reader) ([]RepoGrants, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(csvHeader)
+
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 || !sameHeader(records[0]) {
+		return nil, fmt.Errorf("csv must start with the header %v", csvHeader)
+	}
+
+	var grants []RepoGrants
+	index := map[string]int{}
+	for line, rec := range records[1:] {
+		validFrom, err := parseTime(rec[6])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line+2, err)
+		}
+		validUntil, err := parseTime(rec[7])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line+2, err)
+		}
+
+		i, ok := index[rec[0]]
+		if !ok {
+			i = len(grants)
+			index[rec[0]] = i
+			grants = append(grants, RepoGrants{Repo: rec[0]})
+		}
+		grants[i].Grants = append(grants[i].Grants, RepoPerms{
+			SubjectType: rec[1],
+			RoleTitle:   rec[2],
+			Path:        rec[3],
+			Permission:  rec[4],
+			Effect:      rec[5],
+			ValidFrom:   validFrom,
+			ValidUntil:  validUntil,
+		})
+	}
+
+	return grants, nil
+}
+
+func sameHeader(rec []string) bool {
+	for i, name := range csvHeader {
+		if rec[i] != name {
+			return false
+		}
+	}
+
+	return true
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+func parseTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// grantKey identifies a grant across an export and an import; grants with
+// the same key differ only in permission and validity.
+type grantKey struct {
+	subjectType string
+	roleTitle   string
+	path        string
+	effect      string
+}
+
+func keyOf(g RepoPerms) grantKey {
+	return grantKey{g.SubjectType, g.RoleTitle, g.Path, g.Effect}
+}
+
+// diffGrants compares the current grants of a repository with the desired
+// ones. The desired grants must already be normalized.
+func diffGrants(repo string, current []RepoPermsId, desired []RepoPerms) (*RepoPermsDiff, error) {
+	diff := &RepoPermsDiff{
+		Repo:    repo,
+		Added:   []RepoPerms{},
+		Removed: []RepoPermsId{},
+		Changed: []RepoPermsChange{},
+	}
+
+	want := map[grantKey]RepoPerms{}
+	for _, g := range desired {
+		if _, ok := want[keyOf(g)]; ok {
+			return nil, fmt.Errorf("%w: %s %s on %s in %s", ErrDuplicateGrant, g.SubjectType, g.RoleTitle, g.Path, repo)
+		}
+		want[keyOf(g)] = g
+	}
+
+	matched := map[grantKey]bool{}
+	for _, c := range current {
+		key := keyOf(RepoPerms{SubjectType: c.SubjectType, RoleTitle: c.RoleTitle, Path: c.Path, Effect: c.Effect})
+		g, ok := want[key]
+		if !ok || matched[key] {
+			diff.Removed = append(diff.Removed, c)
+			continue
+		}
+		matched[key] = true
+
+		if !samePermission(c.Permission, g.Permission) || !sameTime(c.ValidFrom, g.ValidFrom) || !sameTime(c.ValidUntil, g.ValidUntil) {
+			diff.Changed = append(diff.Changed, RepoPermsChange{Old: c, New: g})
+		}
+	}
+
+	for _, g := range desired {
+		if !matched[keyOf(g)] {
+			diff.Added = append(diff.Added, g)
+		}
+	}
+
+	return diff, nil
+}
+
+func samePermission(a, b string) bool {
+	pa, errA := ParsePermission(a)
+	pb, errB := ParsePermission(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+
+	return pa == pb
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Equal(*b)
+}
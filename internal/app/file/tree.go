@@ -0,0 +1,93 @@
+package file
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+func toTree(objectKeys []string) []SubDir {
+	dirsMap := make(map[string]Dir)
+
+	if len(objectKeys) == 1 {
+		key := objectKeys[0]
+		if i := strings.IndexByte(key, '/'); i > 0 {
+			nameDir := key[:i]
+			subPath := key[i+1:]
+
+			var (
+				f, sb []string
+				err   error
+			)
+			subPath, err = url.QueryUnescape(subPath)
+			if err != nil {
+
+			} else {
+				if isFile(subPath) {
+					f = []string{subPath}
+				} else {
+					sb = []string{subPath}
+				}
+			}
+
+			dirsMap[nameDir] = Dir{
+				SubDirs: sb,
+				Files:   f,
+			}
+		} else {
+			dirsMap[key] = Dir{}
+		}
+	} else {
+		for _, key := range objectKeys {
+			if i := strings.IndexByte(key, '/'); i > 0 {
+				nameDir := key[:i]
+				subPath := key[i+1:]
+				sb := dirsMap[nameDir].SubDirs
+				f := dirsMap[nameDir].Files
+
+				var err error
+				subPath, err = url.QueryUnescape(subPath)
+				if err != nil {
+
+				} else {
+					if isFile(subPath) {
+						f = append(f, subPath)
+					} else {
+						sb = append(sb, subPath)
+					}
+				}
+
+				dirsMap[nameDir] = Dir{
+					SubDirs: sb,
+					Files:   f,
+				}
+			} else {
+				dirsMap[key] = Dir{}
+			}
+		}
+	}
+
+	subDirs := make([]SubDir, len(dirsMap))
+	i := 0
+	for k, v := range dirsMap {
+		subDirs[i] = SubDir{
+			Name:    k,
+			SubDirs: toTree(v.SubDirs),
+			Files:   v.Files,
+		}
+		i++
+	}
+
+	sort.Slice(subDirs, func(i, j int) bool {
+		return subDirs[j].Name > subDirs[i].Name
+	})
+
+	return subDirs
+}
+
+func isFile(path string) bool {
+	if strings.Contains(path, ".") && !strings.Contains(path, "/") {
+		return true
+	}
+	return false
+}
@@ -4,11 +4,68 @@ type Config struct {
 	BindAddr    string `toml:"bind_addr"`
 	LogLevel    string `toml:"log_level"`
 	DatabaseURL string `toml:"database_url"`
+
+	// GrantSweepInterval is how often expired repository grants are
+	// removed, as a Go duration. Zero disables the sweep.
+	GrantSweepInterval string `toml:"grant_sweep_interval"`
+
+	// InsecureCookies drops the Secure attribute of the session cookies so
+	// that they work over plain http during development.
+	InsecureCookies bool `toml:"insecure_cookies"`
+
+	JWTActiveKey string         `toml:"jwt_active_key"`
+	JWTKeys      []JWTKeyConfig `toml:"jwt_keys"`
+
+	Storage StorageConfig `toml:"storage"`
+
+	OIDC OIDCConfig `toml:"oidc"`
+}
+
+// StorageConfig selects where the content of files is kept: "minio", set up
+// through the ENDPOINT, ACCESS_KEY_ID and SECRET_ACCESS_KEY environment
+// variables, or "localfs", which keeps files below Root.
+type StorageConfig struct {
+	Backend string `toml:"backend"`
+	Root    string `toml:"root"`
+}
+
+// JWTKeyConfig describes one RS*/ES* key. A key with only a public key file
+// can verify tokens but never becomes the active signing key.
+type JWTKeyConfig struct {
+	Kid            string `toml:"kid"`
+	Algorithm      string `toml:"algorithm"`
+	PrivateKeyPath string `toml:"private_key_path"`
+	PublicKeyPath  string `toml:"public_key_path"`
+}
+
+// OIDCConfig enables login through an external identity provider when Issuer
+// is set. The IdP groups of a user are matched against RoleMapping in order
+// and the first hit decides the role; DefaultRole is used when none matches.
+type OIDCConfig struct {
+	Issuer       string            `toml:"issuer"`
+	ClientID     string            `toml:"client_id"`
+	ClientSecret string            `toml:"client_secret"`
+	RedirectURL  string            `toml:"redirect_url"`
+	Scopes       []string          `toml:"scopes"`
+	GroupsClaim  string            `toml:"groups_claim"`
+	DefaultRole  string            `toml:"default_role"`
+	PostLoginURL string            `toml:"post_login_url"`
+	RoleMapping  []OIDCRoleMapping `toml:"role_mapping"`
+}
+
+type OIDCRoleMapping struct {
+	Group string `toml:"group"`
+	Role  string `toml:"role"`
 }
 
 func NewConfig() *Config {
 	return &Config{
-		BindAddr: ":8080",
-		LogLevel: "debug",
+		BindAddr:           ":8080",
+		LogLevel:           "debug",
+		GrantSweepInterval: "1h",
+		Storage: StorageConfig{
+			Backend: "minio",
+			Root:    "data",
+		},
 	}
 }
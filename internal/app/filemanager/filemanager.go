@@ -1,11 +1,18 @@
 package filemanager
 
 import (
+	"context"
 	"database/sql"
+	"files_test_rus/internal/app/file"
+	"files_test_rus/internal/app/file/store/localfs"
 	"files_test_rus/internal/app/file/store/minio"
+	fileperms "files_test_rus/internal/app/file/store/postgres"
+	"files_test_rus/internal/app/oidc"
+	"files_test_rus/internal/app/user/store/postgres"
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -24,16 +31,74 @@ func Start(config *Config, logger *logrus.Logger) error {
 
 	defer db.Close()
 
-	client, err := minio.NewClient(db, endpoint, accessKeyID, secretAccessKey, logger)
+	objects, err := newObjectStore(config.Storage, logger)
 	if err != nil {
-		return fmt.Errorf("failed to create minio client. err: %w", err)
+		return err
+	}
+
+	metadata := fileperms.NewMetadata(db, logger)
+
+	if config.GrantSweepInterval != "" {
+		interval, err := time.ParseDuration(config.GrantSweepInterval)
+		if err != nil {
+			return fmt.Errorf("invalid grant_sweep_interval. err: %w", err)
+		}
+		if interval > 0 {
+			go sweepExpiredPerms(context.Background(), metadata, interval, logger)
+		}
+	}
+
+	authorizer := fileperms.NewAuthorizer(db, logger)
+	userClient := postgres.NewClient(db, logger)
+
+	keys, err := newKeySet(config)
+	if err != nil {
+		return err
+	}
+
+	var login *oidcLogin
+	if config.OIDC.Issuer != "" {
+		provider, err := oidc.NewProvider(context.Background(), oidc.Config{
+			Issuer:       config.OIDC.Issuer,
+			ClientID:     config.OIDC.ClientID,
+			ClientSecret: config.OIDC.ClientSecret,
+			RedirectURL:  config.OIDC.RedirectURL,
+			Scopes:       config.OIDC.Scopes,
+			GroupsClaim:  config.OIDC.GroupsClaim,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set up oidc provider. err: %w", err)
+		}
+		login = &oidcLogin{
+			provider: provider,
+			config:   config.OIDC,
+		}
 	}
 
-	srv := newServer(client, logger)
+	srv := newServer(objects, metadata, authorizer, userClient, keys, login, config.InsecureCookies, logger)
 
 	return http.ListenAndServe(config.BindAddr, srv)
 }
 
+func newObjectStore(config StorageConfig, logger *logrus.Logger) (file.ObjectStore, error) {
+	switch config.Backend {
+	case "", "minio":
+		client, err := minio.NewClient(endpoint, accessKeyID, secretAccessKey, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create minio client. err: %w", err)
+		}
+		return client, nil
+	case "localfs":
+		client, err := localfs.NewClient(config.Root, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create localfs client. err: %w", err)
+		}
+		return client, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", config.Backend)
+	}
+}
+
 func newDB(databaseURL string) (*sql.DB, error) {
 	db, err := sql.Open("postgres", databaseURL)
 	if err != nil {
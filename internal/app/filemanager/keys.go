@@ -0,0 +1,252 @@
+package filemanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/golang-jwt/jwt"
+)
+
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+// keySet holds every key a token may be verified with, selected by the "kid"
+// header. Only the active key signs new tokens; retired keys stay in the set
+// until the tokens they signed have expired, so rotation does not log anyone
+// out.
+type keySet struct {
+	active  *signingKey
+	keys    map[string]*signingKey
+	methods []string
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+var (
+	errUnknownKey      = errors.New("unknown signing key")
+	errUnexpectedAlg   = errors.New("unexpected signing algorithm")
+	errNoActiveKey     = errors.New("no active signing key")
+	errUnsupportedAlg  = errors.New("unsupported signing algorithm")
+	errMissingKeyFiles = errors.New("signing key needs private_key_path or public_key_path")
+	errDuplicateKid    = errors.New("duplicate signing key id")
+	errWrongCurve      = errors.New("elliptic curve does not match the signing algorithm")
+)
+
+// curves are the curves the ECDSA algorithms are defined for.
+var curves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+// newKeySet loads the keys listed in the config. SECRET_KEY, when set, is
+// kept as an HS256 key without a kid so that tokens issued before key
+// rotation was introduced keep working; it only signs if nothing else is
+// configured.
+func newKeySet(config *Config) (*keySet, error) {
+	ks := &keySet{
+		keys: make(map[string]*signingKey),
+	}
+
+	if secret := os.Getenv("SECRET_KEY"); secret != "" {
+		if err := ks.add(&signingKey{
+			method:  jwt.SigningMethodHS256,
+			private: []byte(secret),
+			public:  []byte(secret),
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, kc := range config.JWTKeys {
+		key, err := loadSigningKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load signing key %q. err: %w", kc.Kid, err)
+		}
+		if err := ks.add(key); err != nil {
+			return nil, fmt.Errorf("failed to load signing key %q. err: %w", kc.Kid, err)
+		}
+	}
+
+	if config.JWTActiveKey != "" {
+		ks.active = ks.keys[config.JWTActiveKey]
+	} else {
+		ks.active = ks.keys[""]
+	}
+
+	if ks.active == nil || ks.active.private == nil {
+		return nil, errNoActiveKey
+	}
+
+	return ks, nil
+}
+
+// add puts key in the set. A kid may be used only once; SECRET_KEY takes the
+// empty one.
+func (ks *keySet) add(key *signingKey) error {
+	if _, ok := ks.keys[key.kid]; ok {
+		return errDuplicateKid
+	}
+	ks.keys[key.kid] = key
+
+	for _, m := range ks.methods {
+		if m == key.method.Alg() {
+			return nil
+		}
+	}
+	ks.methods = append(ks.methods, key.method.Alg())
+
+	return nil
+}
+
+func (ks *keySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.active.method, claims)
+	if ks.active.kid != "" {
+		token.Header["kid"] = ks.active.kid
+	}
+
+	return token.SignedString(ks.active.private)
+}
+
+func (ks *keySet) parse(tokenStr string, claims jwt.Claims) (*jwt.Token, error) {
+	parser := &jwt.Parser{ValidMethods: ks.methods}
+	return parser.ParseWithClaims(tokenStr, claims, ks.keyFunc)
+}
+
+// keyFunc picks the verification key by "kid" and refuses tokens whose "alg"
+// differs from the algorithm that key was configured with.
+func (ks *keySet) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, errUnknownKey
+	}
+
+	if t.Method.Alg() != key.method.Alg() {
+		return nil, errUnexpectedAlg
+	}
+
+	return key.public, nil
+}
+
+// jwks returns the public halves of the asymmetric keys. HMAC secrets are
+// never published.
+func (ks *keySet) jwks() jwkSet {
+	set := jwkSet{Keys: []jwk{}}
+
+	for _, key := range ks.keys {
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{
+				Kty: "RSA",
+				Kid: key.kid,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			set.Keys = append(set.Keys, jwk{
+				Kty: "EC",
+				Kid: key.kid,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				Crv: pub.Curve.Params().Name,
+				X:   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size))),
+				Y:   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size))),
+			})
+		}
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+
+	return set
+}
+
+func loadSigningKey(kc JWTKeyConfig) (*signingKey, error) {
+	if kc.PrivateKeyPath == "" && kc.PublicKeyPath == "" {
+		return nil, errMissingKeyFiles
+	}
+
+	key := &signingKey{
+		kid:    kc.Kid,
+		method: jwt.GetSigningMethod(kc.Algorithm),
+	}
+
+	var private, public []byte
+	var err error
+	if kc.PrivateKeyPath != "" {
+		if private, err = os.ReadFile(kc.PrivateKeyPath); err != nil {
+			return nil, err
+		}
+	}
+	if kc.PublicKeyPath != "" {
+		if public, err = os.ReadFile(kc.PublicKeyPath); err != nil {
+			return nil, err
+		}
+	}
+
+	switch key.method.(type) {
+	case *jwt.SigningMethodRSA:
+		if private != nil {
+			priv, err := jwt.ParseRSAPrivateKeyFromPEM(private)
+			if err != nil {
+				return nil, err
+			}
+			key.private, key.public = priv, &priv.PublicKey
+		} else {
+			if key.public, err = jwt.ParseRSAPublicKeyFromPEM(public); err != nil {
+				return nil, err
+			}
+		}
+	case *jwt.SigningMethodECDSA:
+		if private != nil {
+			priv, err := jwt.ParseECPrivateKeyFromPEM(private)
+			if err != nil {
+				return nil, err
+			}
+			key.private, key.public = priv, &priv.PublicKey
+		} else {
+			if key.public, err = jwt.ParseECPublicKeyFromPEM(public); err != nil {
+				return nil, err
+			}
+		}
+
+		if pub := key.public.(*ecdsa.PublicKey); pub.Curve != curves[key.method.Alg()] {
+			return nil, errWrongCurve
+		}
+	default:
+		return nil, errUnsupportedAlg
+	}
+
+	return key, nil
+}
@@ -0,0 +1,33 @@
+package filemanager
+
+import (
+	"time"
+
+	"files_test_rus/internal/app/oidc"
+)
+
+const (
+	oidcStateCookie string        = "oidc_state"
+	oidcNonceCookie string        = "oidc_nonce"
+	oidcFlowTTL     time.Duration = 10 * time.Minute
+)
+
+// oidcLogin is set when an external identity provider is configured.
+type oidcLogin struct {
+	provider *oidc.Provider
+	config   OIDCConfig
+}
+
+// roleFor returns the title of the role the first matching IdP group maps
+// to, or the default role when none of the groups is mapped.
+func (o *oidcLogin) roleFor(groups []string) string {
+	for _, m := range o.config.RoleMapping {
+		for _, g := range groups {
+			if g == m.Group {
+				return m.Role
+			}
+		}
+	}
+
+	return o.config.DefaultRole
+}
@@ -5,147 +5,1134 @@ import (
 	"encoding/json"
 	"errors"
 	storage "files_test_rus/internal/app/file"
-	"files_test_rus/internal/app/file/store/minio"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	model "files_test_rus/internal/app/file"
+	"files_test_rus/internal/app/user"
+	"files_test_rus/internal/app/user/store/postgres"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 )
 
 type server struct {
-	router  *mux.Router
-	logger  *logrus.Logger
-	service storage.Service
+	router      *mux.Router
+	logger      *logrus.Logger
+	service     storage.Service
+	userService user.Service
+	keys        *keySet
+	oidc        *oidcLogin
+
+	insecureCookies bool
 }
 
-type Claims struct {
-	RoleID string `json:"role_id"`
-	Email  string `json:"email"`
-	jwt.StandardClaims
+const (
+	prefix string = "backend/"
+)
+
+func newServer(objects storage.ObjectStore, metadata storage.MetadataStore, authorizer storage.Authorizer, userClient *postgres.Client, keys *keySet, oidcLogin *oidcLogin, insecureCookies bool, logger *logrus.Logger) *server {
+	service, err := storage.NewService(objects, metadata, authorizer, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	userService, err := user.NewService(userClient, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	s := &server{
+		router:      mux.NewRouter(),
+		logger:      logger,
+		service:     service,
+		userService: userService,
+		keys:        keys,
+		oidc:        oidcLogin,
+
+		insecureCookies: insecureCookies,
+	}
+	s.configureRouter()
+
+	return s
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+func (s *server) configureRouter() {
+	s.router.Use(
+		handlers.CORS(
+			handlers.AllowedOrigins([]string{"http://localhost:3000"}),
+			handlers.AllowedMethods([]string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"}),
+			handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"}),
+			handlers.AllowCredentials(),
+		),
+	)
+
+	s.router.HandleFunc("/.well-known/jwks.json", s.handleJWKS()).Methods("GET", "OPTIONS")
+
+	authRouter := s.router.PathPrefix("/auth").Subrouter()
+	authRouter.HandleFunc("/login", s.handleLogin()).Methods("POST", "OPTIONS")
+	authRouter.HandleFunc("/refresh", s.handleRefresh()).Methods("POST", "OPTIONS")
+	authRouter.HandleFunc("/logout", s.handleLogout()).Methods("POST", "OPTIONS")
+	if s.oidc != nil {
+		authRouter.HandleFunc("/oidc/login", s.handleOIDCLogin()).Methods("GET", "OPTIONS")
+		authRouter.HandleFunc("/oidc/callback", s.handleOIDCCallback()).Methods("GET", "OPTIONS")
+	}
+
+	privateRouter := s.router.NewRoute().Subrouter()
+	privateRouter.Use(s.authorizeUser)
+
+	// s.router.HandleFunc("/getallfiles", s.handleGetFiles()).Methods("GET", "OPTIONS")
+	staticRouter := privateRouter.PathPrefix("/static").HandlerFunc(s.handleGetFile())
+	staticRouter.HandlerFunc(s.handleGetFile()).Methods("GET", "OPTIONS")
+
+	fileRouter := privateRouter.PathPrefix("/file").Subrouter()
+	fileRouter.HandleFunc("/upload", s.handleUpload()).Methods("POST", "OPTIONS")
+	fileRouter.HandleFunc("/remove", s.handleRemoveFile()).Methods("DELETE", "OPTIONS")
+	fileRouter.HandleFunc("/rename", s.handleRenameFile()).Methods("POST", "OPTIONS")
+	fileRouter.HandleFunc("/move", s.handleMoveFile()).Methods("POST", "OPTIONS")
+	fileRouter.HandleFunc("/perms", s.handleGetEffectivePermissions()).Methods("GET", "OPTIONS")
+
+	dirRouter := privateRouter.PathPrefix("/dir").Subrouter()
+	dirRouter.HandleFunc("/create", s.handleCreateDirectory()).Methods("POST", "OPTIONS")
+	dirRouter.HandleFunc("/rename", s.handleRenameDirectory()).Methods("POST", "OPTIONS")
+	dirRouter.HandleFunc("/move", s.handleMoveDirectory()).Methods("POST", "OPTIONS")
+	dirRouter.HandleFunc("/remove", s.handleRemoveDirectory()).Methods("DELETE", "OPTIONS")
+
+	sessionRouter := privateRouter.PathPrefix("/sessions").Subrouter()
+	sessionRouter.HandleFunc("/revoke/{userId}", s.handleRevokeSessions()).Methods("DELETE", "OPTIONS")
+
+	userRouter := privateRouter.PathPrefix("/users").Subrouter()
+	userRouter.HandleFunc("/create", s.handleCreateUser()).Methods("POST", "OPTIONS")
+	userRouter.HandleFunc("/get", s.handleGetUsers()).Methods("GET", "OPTIONS")
+	userRouter.HandleFunc("/setrole", s.handleSetUserRole()).Methods("PATCH", "OPTIONS")
+	userRouter.HandleFunc("/disable", s.handleDisableUser()).Methods("PATCH", "OPTIONS")
+	userRouter.HandleFunc("/resetpassword", s.handleResetPassword()).Methods("PATCH", "OPTIONS")
+
+	groupRouter := privateRouter.PathPrefix("/groups").Subrouter()
+	groupRouter.HandleFunc("/create", s.handleCreateGroup()).Methods("POST", "OPTIONS")
+	groupRouter.HandleFunc("/get", s.handleGetGroups()).Methods("GET", "OPTIONS")
+	groupRouter.HandleFunc("/remove", s.handleRemoveGroup()).Methods("DELETE", "OPTIONS")
+	groupRouter.HandleFunc("/adduser", s.handleAddUserToGroup()).Methods("POST", "OPTIONS")
+	groupRouter.HandleFunc("/removeuser", s.handleRemoveUserFromGroup()).Methods("DELETE", "OPTIONS")
+
+	roleRouter := privateRouter.PathPrefix("/roles").Subrouter()
+	roleRouter.HandleFunc("/create", s.handleCreateRole()).Methods("POST", "OPTIONS")
+	roleRouter.HandleFunc("/get", s.handleGetRoles()).Methods("GET", "OPTIONS")
+	roleRouter.HandleFunc("/rename", s.handleRenameRole()).Methods("PATCH", "OPTIONS")
+	roleRouter.HandleFunc("/setparent", s.handleSetRoleParent()).Methods("PATCH", "OPTIONS")
+	roleRouter.HandleFunc("/remove", s.handleRemoveRole()).Methods("DELETE", "OPTIONS")
+
+	saRouter := privateRouter.PathPrefix("/sa").Subrouter()
+	saRouter.HandleFunc("/create", s.handleCreateServiceAccount()).Methods("POST", "OPTIONS")
+	saRouter.HandleFunc("/get", s.handleGetServiceAccounts()).Methods("GET", "OPTIONS")
+	saRouter.HandleFunc("/addkey/{accountId}", s.handleCreateApiKey()).Methods("POST", "OPTIONS")
+	saRouter.HandleFunc("/getkeys/{accountId}", s.handleGetApiKeys()).Methods("GET", "OPTIONS")
+	saRouter.HandleFunc("/revokekey/{keyId}", s.handleRevokeApiKey()).Methods("DELETE", "OPTIONS")
+
+	repRouter := privateRouter.PathPrefix("/rep").Subrouter()
+	repRouter.HandleFunc("/create", s.handleCreateRepository()).Methods("POST", "OPTIONS")
+	repRouter.HandleFunc("/get", s.handleGetRepositories()).Methods("GET", "OPTIONS")
+	repRouter.HandleFunc("/getfiles/{repoName}", s.handleGetRepositoryFiles()).Methods("GET", "OPTIONS")
+	repRouter.HandleFunc("/getperms/{repoName}", s.handleGetRepositoryPerms()).Methods("GET", "OPTIONS")
+	repRouter.HandleFunc("/addperms/{repoName}", s.handleAddRepositoryPerms()).Methods("POST", "OPTIONS")
+	repRouter.HandleFunc("/editperms/{repoName}", s.handleEditRepositoryPerms()).Methods("PATCH", "OPTIONS")
+	repRouter.HandleFunc("/removeperms/{repoName}", s.handleRemoveRepositoryPerms()).Methods("DELETE", "OPTIONS")
+	repRouter.HandleFunc("/getadmins/{repoName}", s.handleGetRepositoryAdmins()).Methods("GET", "OPTIONS")
+	repRouter.HandleFunc("/addadmin/{repoName}", s.handleAddRepositoryAdmin()).Methods("POST", "OPTIONS")
+	repRouter.HandleFunc("/removeadmin/{repoName}", s.handleRemoveRepositoryAdmin()).Methods("DELETE", "OPTIONS")
+	repRouter.HandleFunc("/explain", s.handleExplainPermission()).Methods("POST", "OPTIONS")
+	repRouter.HandleFunc("/exportperms", s.handleExportPerms()).Methods("GET", "OPTIONS")
+	repRouter.HandleFunc("/importperms", s.handleImportPerms()).Methods("POST", "OPTIONS")
+
+	accessRouter := privateRouter.PathPrefix("/access").Subrouter()
+	accessRouter.HandleFunc("/request", s.handleRequestAccess()).Methods("POST", "OPTIONS")
+	accessRouter.HandleFunc("/get", s.handleGetAccessRequests()).Methods("GET", "OPTIONS")
+	accessRouter.HandleFunc("/approve/{requestId}", s.handleDecideAccessRequest(true)).Methods("POST", "OPTIONS")
+	accessRouter.HandleFunc("/reject/{requestId}", s.handleDecideAccessRequest(false)).Methods("POST", "OPTIONS")
+
+}
+
+func (s *server) authorizeUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenStr, err := tokenFromRequest(r)
+		if err != nil {
+			if err == http.ErrNoCookie {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if strings.HasPrefix(tokenStr, user.ApiKeyPrefix) {
+			sa, key, err := s.userService.AuthenticateApiKey(r.Context(), tokenStr)
+			if err != nil {
+				if errors.Is(err, user.ErrInvalidToken) {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				s.error(w, r, http.StatusInternalServerError, err)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), "role", strconv.Itoa(sa.RoleId))
+			ctx = context.WithValue(ctx, "repositories", key.Repositories)
+			r = r.WithContext(ctx)
+
+			rw := &responseWriter{w, http.StatusOK}
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		claims := &Claims{}
+
+		tkn, err := s.keys.parse(tokenStr, claims)
+
+		if err != nil {
+			var ve *jwt.ValidationError
+			if errors.As(err, &ve) && ve.Errors&(jwt.ValidationErrorSignatureInvalid|jwt.ValidationErrorUnverifiable|jwt.ValidationErrorExpired) != 0 {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if !tkn.Valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		userId, _ := strconv.Atoi(claims.Subject)
+		revoked, err := s.userService.IsTokenRevoked(r.Context(), claims.Id, userId, time.Unix(claims.IssuedAt, 0))
+		if err != nil {
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if revoked {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "role", claims.RoleID)
+		ctx = context.WithValue(ctx, "groups", claims.Groups)
+		ctx = context.WithValue(ctx, "user_id", claims.Subject)
+		ctx = context.WithValue(ctx, "email", claims.Email)
+		r = r.WithContext(ctx)
+
+		rw := &responseWriter{w, http.StatusOK}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+func (s *server) handleJWKS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		s.respond(w, r, http.StatusOK, s.keys.jwks())
+	}
+}
+
+func (s *server) handleLogin() http.HandlerFunc {
+	type request struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("LOGIN")
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		u, err := s.userService.Login(r.Context(), user.Login{
+			Email:    req.Email,
+			Password: req.Password,
+		})
+		if err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		resp, err := s.issueTokens(w, r, u)
+		if err != nil {
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *server) handleOIDCLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("OIDC LOGIN")
+		state := uuid.NewString()
+		nonce := uuid.NewString()
+
+		for name, value := range map[string]string{oidcStateCookie: state, oidcNonceCookie: nonce} {
+			s.setCookie(w, &http.Cookie{
+				Name:     name,
+				Value:    value,
+				Path:     "/auth/oidc",
+				MaxAge:   int(oidcFlowTTL.Seconds()),
+				HttpOnly: true,
+			})
+		}
+
+		http.Redirect(w, r, s.oidc.provider.AuthCodeURL(state, nonce), http.StatusFound)
+	}
+}
+
+func (s *server) handleOIDCCallback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("OIDC CALLBACK")
+		query := r.URL.Query()
+		if e := query.Get("error"); e != "" {
+			s.error(w, r, http.StatusUnauthorized, fmt.Errorf("identity provider error: %s", e))
+			return
+		}
+
+		state, err := r.Cookie(oidcStateCookie)
+		if err != nil || state.Value == "" || state.Value != query.Get("state") {
+			s.error(w, r, http.StatusBadRequest, errors.New("invalid oidc state"))
+			return
+		}
+		nonce, err := r.Cookie(oidcNonceCookie)
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, errors.New("missing oidc nonce"))
+			return
+		}
+
+		for _, name := range []string{oidcStateCookie, oidcNonceCookie} {
+			s.setCookie(w, &http.Cookie{Name: name, Path: "/auth/oidc", MaxAge: -1, HttpOnly: true})
+		}
+
+		identity, err := s.oidc.provider.Exchange(r.Context(), query.Get("code"), nonce.Value)
+		if err != nil {
+			s.error(w, r, http.StatusUnauthorized, err)
+			return
+		}
+
+		roleTitle := s.oidc.roleFor(identity.Groups)
+		if roleTitle == "" {
+			s.error(w, r, http.StatusForbidden, errors.New("no role is mapped to the identity provider groups"))
+			return
+		}
+
+		u, err := s.userService.ExternalLogin(r.Context(), user.ExternalIdentity{
+			Issuer:  identity.Issuer,
+			Subject: identity.Subject,
+			Email:   identity.Email,
+		}, roleTitle)
+		if err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		resp, err := s.issueTokens(w, r, u)
+		if err != nil {
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		if s.oidc.config.PostLoginURL != "" {
+			http.Redirect(w, r, s.oidc.config.PostLoginURL, http.StatusFound)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *server) handleRefresh() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("REFRESH TOKEN")
+		cookie, err := r.Cookie(refreshCookie)
+		if err != nil {
+			s.error(w, r, http.StatusUnauthorized, err)
+			return
+		}
+
+		u, refreshToken, err := s.userService.Refresh(r.Context(), cookie.Value)
+		if err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		resp, err := s.setAccessToken(w, u)
+		if err != nil {
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		s.setRefreshToken(w, refreshToken)
+
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *server) handleLogout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("LOGOUT")
+		if tokenStr, err := tokenFromRequest(r); err == nil {
+			claims := &Claims{}
+			if _, err := s.keys.parse(tokenStr, claims); err == nil {
+				if err := s.userService.RevokeToken(r.Context(), claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+					s.error(w, r, http.StatusInternalServerError, err)
+					return
+				}
+			}
+		}
+
+		if cookie, err := r.Cookie(refreshCookie); err == nil {
+			if err := s.userService.RevokeRefreshToken(r.Context(), cookie.Value); err != nil {
+				s.error(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+
+		s.clearTokens(w)
+
+		s.respond(w, r, http.StatusOK, nil)
+	}
+}
+
+func (s *server) handleRevokeSessions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("REVOKE SESSIONS")
+		vars := mux.Vars(r)
+		userId, err := strconv.Atoi(vars["userId"])
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.userService.RevokeSessions(r.Context(), userId); err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, nil)
+	}
+}
+
+func (s *server) handleCreateUser() http.HandlerFunc {
+	type request struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		RoleId   int    `json:"role_id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("CREATE USER")
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		u, err := s.userService.CreateUser(r.Context(), user.User{
+			Email:    req.Email,
+			Password: req.Password,
+			RoleId:   req.RoleId,
+		})
+		if err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusCreated, u)
+	}
+}
+
+func (s *server) handleGetUsers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("GET LIST OF USERS")
+		users, err := s.userService.GetUsers(r.Context(), r.URL.Query().Get("search"))
+		if err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, users)
+	}
+}
+
+func (s *server) handleSetUserRole() http.HandlerFunc {
+	type request struct {
+		Id     int `json:"id"`
+		RoleId int `json:"role_id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("SET USER ROLE")
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.userService.SetUserRole(r.Context(), req.Id, req.RoleId); err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, nil)
+	}
+}
+
+func (s *server) handleDisableUser() http.HandlerFunc {
+	type request struct {
+		Id       int  `json:"id"`
+		Disabled bool `json:"disabled"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("DISABLE USER")
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.userService.SetUserDisabled(r.Context(), req.Id, req.Disabled); err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, nil)
+	}
+}
+
+func (s *server) handleResetPassword() http.HandlerFunc {
+	type request struct {
+		Id       int    `json:"id"`
+		Password string `json:"password"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("RESET PASSWORD")
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.userService.ResetPassword(r.Context(), req.Id, req.Password); err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, nil)
+	}
+}
+
+func (s *server) handleCreateRole() http.HandlerFunc {
+	type request struct {
+		Title    string `json:"title"`
+		ParentId *int   `json:"parent_id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("CREATE ROLE")
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		role, err := s.userService.CreateRole(r.Context(), user.Role{Title: req.Title, ParentId: req.ParentId})
+		if err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusCreated, role)
+	}
+}
+
+func (s *server) handleGetRoles() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("GET LIST OF ROLES")
+		roles, err := s.userService.GetRoles(r.Context())
+		if err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, roles)
+	}
+}
+
+func (s *server) handleRenameRole() http.HandlerFunc {
+	type request struct {
+		Id    int    `json:"id"`
+		Title string `json:"title"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("RENAME ROLE")
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		role := user.Role{
+			Id:    req.Id,
+			Title: req.Title,
+		}
+
+		if err := s.userService.RenameRole(r.Context(), role); err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, role)
+	}
+}
+
+func (s *server) handleSetRoleParent() http.HandlerFunc {
+	type request struct {
+		Id       int  `json:"id"`
+		ParentId *int `json:"parent_id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("SET ROLE PARENT")
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.userService.SetRoleParent(r.Context(), req.Id, req.ParentId); err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, req)
+	}
+}
+
+func (s *server) handleRemoveRole() http.HandlerFunc {
+	type request struct {
+		Id int `json:"id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("REMOVE ROLE")
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.userService.RemoveRole(r.Context(), req.Id); err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, nil)
+	}
+}
+
+func (s *server) handleCreateGroup() http.HandlerFunc {
+	type request struct {
+		Title string `json:"title"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("CREATE GROUP")
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		group, err := s.userService.CreateGroup(r.Context(), user.Group{Title: req.Title})
+		if err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusCreated, group)
+	}
+}
+
+func (s *server) handleGetGroups() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("GET LIST OF GROUPS")
+		groups, err := s.userService.GetGroups(r.Context())
+		if err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, groups)
+	}
+}
+
+func (s *server) handleRemoveGroup() http.HandlerFunc {
+	type request struct {
+		Id int `json:"id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("REMOVE GROUP")
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.userService.RemoveGroup(r.Context(), req.Id); err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, nil)
+	}
+}
+
+func (s *server) handleAddUserToGroup() http.HandlerFunc {
+	type request struct {
+		UserId  int `json:"user_id"`
+		GroupId int `json:"group_id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("ADD USER TO GROUP")
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.userService.AddUserToGroup(r.Context(), req.UserId, req.GroupId); err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, nil)
+	}
+}
+
+func (s *server) handleRemoveUserFromGroup() http.HandlerFunc {
+	type request struct {
+		UserId  int `json:"user_id"`
+		GroupId int `json:"group_id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("REMOVE USER FROM GROUP")
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.userService.RemoveUserFromGroup(r.Context(), req.UserId, req.GroupId); err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, nil)
+	}
+}
+
+func (s *server) handleCreateServiceAccount() http.HandlerFunc {
+	type request struct {
+		Name   string `json:"name"`
+		RoleId int    `json:"role_id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("CREATE SERVICE ACCOUNT")
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		sa, err := s.userService.CreateServiceAccount(r.Context(), user.ServiceAccount{
+			Name:   req.Name,
+			RoleId: req.RoleId,
+		})
+		if err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusCreated, sa)
+	}
+}
+
+func (s *server) handleGetServiceAccounts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("GET LIST OF SERVICE ACCOUNTS")
+		accounts, err := s.userService.GetServiceAccounts(r.Context())
+		if err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, accounts)
+	}
+}
+
+func (s *server) handleCreateApiKey() http.HandlerFunc {
+	type request struct {
+		Repositories []string `json:"repositories"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("CREATE API KEY")
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		vars := mux.Vars(r)
+		accountId, err := strconv.Atoi(vars["accountId"])
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		key, err := s.userService.CreateApiKey(r.Context(), accountId, req.Repositories)
+		if err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusCreated, key)
+	}
 }
 
-var (
-	jwtKey = []byte(os.Getenv("SECRET_KEY"))
-)
+func (s *server) handleGetApiKeys() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("GET LIST OF API KEYS")
+		vars := mux.Vars(r)
+		accountId, err := strconv.Atoi(vars["accountId"])
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
 
-const (
-	prefix string = "backend/"
-)
+		keys, err := s.userService.GetApiKeys(r.Context(), accountId)
+		if err != nil {
+			s.userError(w, r, err)
+			return
+		}
 
-func newServer(client *minio.Client, logger *logrus.Logger) *server {
-	service, err := storage.NewService(client, logger)
-	if err != nil {
-		logger.Fatal(err)
+		s.respond(w, r, http.StatusOK, keys)
 	}
+}
 
-	s := &server{
-		router:  mux.NewRouter(),
-		logger:  logger,
-		service: service,
+func (s *server) handleRevokeApiKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("REVOKE API KEY")
+		vars := mux.Vars(r)
+		keyId, err := strconv.Atoi(vars["keyId"])
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.userService.RevokeApiKey(r.Context(), keyId); err != nil {
+			s.userError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, nil)
 	}
-	s.configureRouter()
+}
 
-	return s
+func (s *server) handleExplainPermission() http.HandlerFunc {
+	type request struct {
+		Role   string `json:"role"`
+		User   string `json:"user"`
+		Path   string `json:"path"`
+		Action string `json:"action"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		s.logger.Info("EXPLAIN PERMISSION")
+
+		action, err := model.ParsePermission(req.Action)
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		var p model.Principal
+		switch {
+		case req.User != "":
+			u, err := s.userService.FindUser(r.Context(), req.User)
+			if err != nil {
+				s.userError(w, r, err)
+				return
+			}
+			p = model.Principal{
+				RoleId: strconv.Itoa(u.RoleId),
+				Groups: u.Groups,
+				UserId: strconv.Itoa(u.Id),
+				Email:  u.Email,
+			}
+		case req.Role != "":
+			role, err := s.userService.FindRoleByTitle(r.Context(), req.Role)
+			if err != nil {
+				s.userError(w, r, err)
+				return
+			}
+			p = model.Principal{RoleId: strconv.Itoa(role.Id)}
+		default:
+			s.error(w, r, http.StatusBadRequest, errors.New("either role or user is required"))
+			return
+		}
+
+		explanation, err := s.service.Explain(r.Context(), p, action, req.Path)
+		if err != nil {
+			s.fileError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, explanation)
+	}
 }
 
-func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.router.ServeHTTP(w, r)
+func (s *server) handleGetRepositoryAdmins() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("GET REPOSITORY ADMINS")
+		vars := mux.Vars(r)
+		repoName := vars["repoName"]
+
+		admins, err := s.service.GetRepositoryAdmins(r.Context(), repoName)
+		if err != nil {
+			s.fileError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, admins)
+	}
 }
 
-func (s *server) configureRouter() {
-	s.router.Use(
-		handlers.CORS(
-			handlers.AllowedOrigins([]string{"http://localhost:3000"}),
-			handlers.AllowedMethods([]string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"}),
-			handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type"}),
-			handlers.AllowCredentials(),
-		),
-		s.authorizeUser)
+func (s *server) handleAddRepositoryAdmin() http.HandlerFunc {
+	type request struct {
+		SubjectType string `json:"subject_type"`
+		Subject     string `json:"subject"`
+		Level       string `json:"level"`
+	}
 
-	// s.router.HandleFunc("/getallfiles", s.handleGetFiles()).Methods("GET", "OPTIONS")
-	staticRouter := s.router.PathPrefix("/static").HandlerFunc(s.handleGetFile())
-	staticRouter.HandlerFunc(s.handleGetFile()).Methods("GET", "OPTIONS")
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		s.logger.Info("ADD REPOSITORY ADMIN")
+		vars := mux.Vars(r)
+		repoName := vars["repoName"]
 
-	fileRouter := s.router.PathPrefix("/file").Subrouter()
-	fileRouter.HandleFunc("/upload", s.handleUpload()).Methods("POST", "OPTIONS")
-	fileRouter.HandleFunc("/remove", s.handleRemoveFile()).Methods("DELETE", "OPTIONS")
-	fileRouter.HandleFunc("/rename", s.handleRenameFile()).Methods("POST", "OPTIONS")
-	fileRouter.HandleFunc("/move", s.handleMoveFile()).Methods("POST", "OPTIONS")
+		admin := &model.RepoAdmin{
+			SubjectType: req.SubjectType,
+			Subject:     req.Subject,
+			Level:       req.Level,
+		}
 
-	dirRouter := s.router.PathPrefix("/dir").Subrouter()
-	dirRouter.HandleFunc("/create", s.handleCreateDirectory()).Methods("POST", "OPTIONS")
-	dirRouter.HandleFunc("/rename", s.handleRenameDirectory()).Methods("POST", "OPTIONS")
-	dirRouter.HandleFunc("/move", s.handleMoveDirectory()).Methods("POST", "OPTIONS")
-	dirRouter.HandleFunc("/remove", s.handleRemoveDirectory()).Methods("DELETE", "OPTIONS")
+		if err := s.service.AddRepositoryAdmin(r.Context(), repoName, *admin); err != nil {
+			s.fileError(w, r, http.StatusInternalServerError, err)
+			return
+		}
 
-	repRouter := s.router.PathPrefix("/rep").Subrouter()
-	repRouter.HandleFunc("/create", s.handleCreateRepository()).Methods("POST", "OPTIONS")
-	repRouter.HandleFunc("/get", s.handleGetRepositories()).Methods("GET", "OPTIONS")
-	repRouter.HandleFunc("/getfiles/{repoName}", s.handleGetRepositoryFiles()).Methods("GET", "OPTIONS")
-	repRouter.HandleFunc("/getperms/{repoName}", s.handleGetRepositoryPerms()).Methods("GET", "OPTIONS")
-	repRouter.HandleFunc("/addperms/{repoName}", s.handleAddRepositoryPerms()).Methods("POST", "OPTIONS")
-	repRouter.HandleFunc("/editperms/{repoName}", s.handleEditRepositoryPerms()).Methods("PATCH", "OPTIONS")
-	repRouter.HandleFunc("/removeperms/{repoName}", s.handleRemoveRepositoryPerms()).Methods("DELETE", "OPTIONS")
+		s.respond(w, r, http.StatusOK, admin)
+	}
+}
+
+func (s *server) handleRemoveRepositoryAdmin() http.HandlerFunc {
+	type request struct {
+		SubjectType string `json:"subject_type"`
+		Subject     string `json:"subject"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		s.logger.Info("REMOVE REPOSITORY ADMIN")
+		vars := mux.Vars(r)
+		repoName := vars["repoName"]
+
+		admin := &model.RepoAdmin{
+			SubjectType: req.SubjectType,
+			Subject:     req.Subject,
+		}
+
+		if err := s.service.RemoveRepositoryAdmin(r.Context(), repoName, *admin); err != nil {
+			s.fileError(w, r, http.StatusInternalServerError, err)
+			return
+		}
 
+		s.respond(w, r, http.StatusOK, nil)
+	}
 }
 
-func (s *server) authorizeUser(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("token")
+func (s *server) handleRequestAccess() http.HandlerFunc {
+	type request struct {
+		Path          string `json:"path"`
+		Permission    string `json:"permission"`
+		Justification string `json:"justification"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		s.logger.Info("REQUEST ACCESS")
+
+		ar, err := s.service.RequestAccess(r.Context(), model.AccessRequest{
+			Path:          req.Path,
+			Permission:    req.Permission,
+			Justification: req.Justification,
+		})
 		if err != nil {
-			if err == http.ErrNoCookie {
-				w.WriteHeader(http.StatusUnauthorized)
-				return
-			}
-			w.WriteHeader(http.StatusBadRequest)
+			s.fileError(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
-		tokenStr := cookie.Value
+		s.respond(w, r, http.StatusCreated, ar)
+	}
+}
 
-		claims := &Claims{}
+func (s *server) handleGetAccessRequests() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("GET ACCESS REQUESTS")
 
-		tkn, err := jwt.ParseWithClaims(tokenStr, claims,
-			func(t *jwt.Token) (interface{}, error) {
-				return jwtKey, nil
-			})
+		requests, err := s.service.GetAccessRequests(r.Context(), r.URL.Query().Get("status"))
+		if err != nil {
+			s.fileError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, requests)
+	}
+}
 
+// handleDecideAccessRequest approves or rejects a request; the body with the
+// note of the decision is optional.
+func (s *server) handleDecideAccessRequest(approve bool) http.HandlerFunc {
+	type request struct {
+		Note string `json:"note"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil && !errors.Is(err, io.EOF) {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		s.logger.Info("DECIDE ACCESS REQUEST")
+		vars := mux.Vars(r)
+		requestId, err := strconv.Atoi(vars["requestId"])
 		if err != nil {
-			if err == jwt.ErrSignatureInvalid {
-				w.WriteHeader(http.StatusUnauthorized)
-				return
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		ar, err := s.service.DecideAccessRequest(r.Context(), requestId, approve, req.Note)
+		if err != nil {
+			s.fileError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, ar)
+	}
+}
+
+func (s *server) handleExportPerms() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("EXPORT PERMS")
+
+		grants, err := s.service.ExportPerms(r.Context(), r.URL.Query().Get("repo"))
+		if err != nil {
+			s.fileError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		if r.URL.Query().Get("format") == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", `attachment; filename="perms.csv"`)
+			if err := model.WriteGrantsCSV(w, *grants); err != nil {
+				s.logger.Errorf("failed to write perms csv. err: %v", err)
 			}
-			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		if !tkn.Valid {
-			w.WriteHeader(http.StatusUnauthorized)
+		s.respond(w, r, http.StatusOK, grants)
+	}
+}
+
+func (s *server) handleImportPerms() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("IMPORT PERMS")
+
+		var (
+			grants []model.RepoGrants
+			err    error
+		)
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+			grants, err = model.ReadGrantsCSV(r.Body)
+		} else {
+			err = json.NewDecoder(r.Body).Decode(&grants)
+		}
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), "role", claims.RoleID)
-		r = r.WithContext(ctx)
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
 
-		rw := &responseWriter{w, http.StatusOK}
-		next.ServeHTTP(rw, r)
-	})
+		diffs, err := s.service.ImportPerms(r.Context(), grants, dryRun)
+		if err != nil {
+			s.fileError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, diffs)
+	}
 }
 
 func (s *server) handleRemoveRepositoryPerms() http.HandlerFunc {
 	type request struct {
-		RoleTitle  string `json:"role_title"`
-		Path       string `json:"path"`
-		Permission string `json:"permission"`
+		SubjectType string     `json:"subject_type"`
+		RoleTitle   string     `json:"role_title"`
+		Path        string     `json:"path"`
+		Permission  string     `json:"permission"`
+		Effect      string     `json:"effect"`
+		ValidFrom   *time.Time `json:"valid_from"`
+		ValidUntil  *time.Time `json:"valid_until"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -159,13 +1146,17 @@ func (s *server) handleRemoveRepositoryPerms() http.HandlerFunc {
 		repoName := vars["repoName"]
 
 		rp := &model.RepoPerms{
-			RoleTitle:  req.RoleTitle,
-			Path:       req.Path,
-			Permission: req.Permission,
+			SubjectType: req.SubjectType,
+			RoleTitle:   req.RoleTitle,
+			Path:        req.Path,
+			Permission:  req.Permission,
+			Effect:      req.Effect,
+			ValidFrom:   req.ValidFrom,
+			ValidUntil:  req.ValidUntil,
 		}
 
 		if err := s.service.RemoveRepositoryPerms(r.Context(), repoName, *rp); err != nil {
-			s.error(w, r, http.StatusForbidden, err)
+			s.fileError(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
@@ -175,10 +1166,14 @@ func (s *server) handleRemoveRepositoryPerms() http.HandlerFunc {
 
 func (s *server) handleEditRepositoryPerms() http.HandlerFunc {
 	type request struct {
-		Id         int    `json:"id"`
-		RoleTitle  string `json:"role_title"`
-		Path       string `json:"path"`
-		Permission string `json:"permission"`
+		Id          int        `json:"id"`
+		SubjectType string     `json:"subject_type"`
+		RoleTitle   string     `json:"role_title"`
+		Path        string     `json:"path"`
+		Permission  string     `json:"permission"`
+		Effect      string     `json:"effect"`
+		ValidFrom   *time.Time `json:"valid_from"`
+		ValidUntil  *time.Time `json:"valid_until"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -192,14 +1187,18 @@ func (s *server) handleEditRepositoryPerms() http.HandlerFunc {
 		repoName := vars["repoName"]
 
 		rp := &model.RepoPermsId{
-			Id:         req.Id,
-			RoleTitle:  req.RoleTitle,
-			Path:       req.Path,
-			Permission: req.Permission,
+			Id:          req.Id,
+			SubjectType: req.SubjectType,
+			RoleTitle:   req.RoleTitle,
+			Path:        req.Path,
+			Permission:  req.Permission,
+			Effect:      req.Effect,
+			ValidFrom:   req.ValidFrom,
+			ValidUntil:  req.ValidUntil,
 		}
 
 		if err := s.service.EditRepositoryPerms(r.Context(), repoName, *rp); err != nil {
-			s.error(w, r, http.StatusForbidden, err)
+			s.fileError(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
@@ -209,9 +1208,13 @@ func (s *server) handleEditRepositoryPerms() http.HandlerFunc {
 
 func (s *server) handleAddRepositoryPerms() http.HandlerFunc {
 	type request struct {
-		RoleTitle  string `json:"role_title"`
-		Path       string `json:"path"`
-		Permission string `json:"permission"`
+		SubjectType string     `json:"subject_type"`
+		RoleTitle   string     `json:"role_title"`
+		Path        string     `json:"path"`
+		Permission  string     `json:"permission"`
+		Effect      string     `json:"effect"`
+		ValidFrom   *time.Time `json:"valid_from"`
+		ValidUntil  *time.Time `json:"valid_until"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -225,13 +1228,17 @@ func (s *server) handleAddRepositoryPerms() http.HandlerFunc {
 		repoName := vars["repoName"]
 
 		rp := &model.RepoPerms{
-			RoleTitle:  req.RoleTitle,
-			Path:       req.Path,
-			Permission: req.Permission,
+			SubjectType: req.SubjectType,
+			RoleTitle:   req.RoleTitle,
+			Path:        req.Path,
+			Permission:  req.Permission,
+			Effect:      req.Effect,
+			ValidFrom:   req.ValidFrom,
+			ValidUntil:  req.ValidUntil,
 		}
 
 		if err := s.service.AddRepositoryPerms(r.Context(), repoName, *rp); err != nil {
-			s.error(w, r, http.StatusForbidden, err)
+			s.fileError(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
@@ -247,7 +1254,7 @@ func (s *server) handleGetRepositoryPerms() http.HandlerFunc {
 
 		files, err := s.service.GetRepositoryPerms(r.Context(), repoName)
 		if err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+			s.fileError(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
@@ -263,7 +1270,7 @@ func (s *server) handleGetRepositoryFiles() http.HandlerFunc {
 
 		files, err := s.service.GetRepositoryFiles(r.Context(), repoName)
 		if err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+			s.fileError(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
@@ -276,7 +1283,7 @@ func (s *server) handleGetRepositories() http.HandlerFunc {
 		s.logger.Info("GET LIST OF REPOSITORIES")
 		files, err := s.service.GetRepositories(r.Context())
 		if err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+			s.fileError(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
@@ -329,7 +1336,7 @@ func (s *server) handleUpload() http.HandlerFunc {
 			}
 
 			if err := s.service.UploadFile(r.Context(), &f); err != nil {
-				s.error(w, r, http.StatusBadRequest, err)
+				s.fileError(w, r, http.StatusBadRequest, err)
 			}
 		}
 
@@ -344,18 +1351,29 @@ func (s *server) handleGetFile() http.HandlerFunc {
 		if !(fileName == "") {
 			file, err := s.service.GetFile(r.Context(), prefix+fileName)
 			if err != nil {
-				s.error(w, r, http.StatusInternalServerError, err)
+				s.fileError(w, r, http.StatusInternalServerError, err)
 				return
 			}
-			w.Header().Set("Content-Length", strconv.Itoa(int(file.Size)))
-			w.Header().Set("Content-Type", file.Type)
-			io.Copy(w, file.Obj)
-			s.respond(w, r, http.StatusOK, file)
+			defer file.Obj.Close()
+
+			if file.Type != "" {
+				w.Header().Set("Content-Type", file.Type)
+			}
+			// The type is whatever the uploader claimed; a sandbox keeps an
+			// uploaded page from running with the session of the reader.
+			w.Header().Set("Content-Security-Policy", "sandbox")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			if file.ETag != "" {
+				w.Header().Set("ETag", strconv.Quote(file.ETag))
+			}
+			// ServeContent answers conditional and range requests from the
+			// ETag and the modification time.
+			http.ServeContent(w, r, file.Id, file.ModTime, file.Obj)
 		} else {
 			s.logger.Info("Get files from bucket")
 			files, err := s.service.GetFiles(r.Context())
 			if err != nil {
-				s.error(w, r, http.StatusInternalServerError, err)
+				s.fileError(w, r, http.StatusInternalServerError, err)
 				return
 			}
 
@@ -366,6 +1384,20 @@ func (s *server) handleGetFile() http.HandlerFunc {
 	}
 }
 
+func (s *server) handleGetEffectivePermissions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logger.Info("GET EFFECTIVE PERMISSIONS")
+
+		perms, err := s.service.GetEffectivePermissions(r.Context(), r.URL.Query().Get("path"))
+		if err != nil {
+			s.fileError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, perms)
+	}
+}
+
 func (s *server) handleRemoveFile() http.HandlerFunc {
 	type request struct {
 		Filename string `json:"filename"`
@@ -384,7 +1416,7 @@ func (s *server) handleRemoveFile() http.HandlerFunc {
 		filename := prefix + req.Filename
 
 		if err := s.service.RemoveFile(r.Context(), filename); err != nil {
-			s.error(w, r, http.StatusConflict, err)
+			s.fileError(w, r, http.StatusConflict, err)
 			return
 		}
 
@@ -403,7 +1435,7 @@ func (s *server) handleRenameFile() http.HandlerFunc {
 		req.Old = prefix + req.Old
 
 		if err := s.service.RenameFile(r.Context(), *req); err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+			s.fileError(w, r, http.StatusInternalServerError, err)
 		}
 
 		s.respond(w, r, http.StatusOK, nil)
@@ -422,7 +1454,7 @@ func (s *server) handleMoveFile() http.HandlerFunc {
 		req.Src = prefix + req.Src
 
 		if err := s.service.MoveFile(r.Context(), *req); err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+			s.fileError(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
@@ -443,7 +1475,7 @@ func (s *server) handleCreateDirectory() http.HandlerFunc {
 		}
 
 		if err := s.service.CreateDirectory(r.Context(), prefix+req.Dir); err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+			s.fileError(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
@@ -464,7 +1496,7 @@ func (s *server) handleCreateRepository() http.HandlerFunc {
 		}
 
 		if err := s.service.CreateRepository(r.Context(), prefix+req.Dir); err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+			s.fileError(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
@@ -485,7 +1517,7 @@ func (s *server) handleRenameDirectory() http.HandlerFunc {
 		req.Old = prefix + req.Old
 
 		if err := s.service.RenameDirectory(r.Context(), *req); err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+			s.fileError(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
@@ -506,7 +1538,7 @@ func (s *server) handleMoveDirectory() http.HandlerFunc {
 		req.Src = prefix + req.Src
 
 		if err := s.service.MoveDirectory(r.Context(), *req); err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+			s.fileError(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
@@ -528,7 +1560,7 @@ func (s *server) handleRemoveDirectory() http.HandlerFunc {
 		}
 
 		if err := s.service.RemoveDirectory(r.Context(), prefix+req.Dir); err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+			s.fileError(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
@@ -546,3 +1578,44 @@ func (s *server) respond(w http.ResponseWriter, r *http.Request, code int, data
 func (s *server) error(w http.ResponseWriter, r *http.Request, code int, err error) {
 	s.respond(w, r, code, map[string]string{"error": err.Error()})
 }
+
+// fileError responds with the status matching an error of the file service,
+// or with status when the error is not one of its own.
+func (s *server) fileError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	switch {
+	case errors.Is(err, model.ErrPermissionDenied), errors.Is(err, model.ErrOutOfScope):
+		s.error(w, r, http.StatusForbidden, err)
+	case errors.Is(err, model.ErrInvalidSubject), errors.Is(err, model.ErrInvalidPermission),
+		errors.Is(err, model.ErrInvalidEffect), errors.Is(err, model.ErrInvalidValidity),
+		errors.Is(err, model.ErrDuplicateGrant), errors.Is(err, model.ErrInvalidLevel),
+		errors.Is(err, model.ErrInvalidRequest), errors.Is(err, model.ErrInvalidRepoName):
+		s.error(w, r, http.StatusBadRequest, err)
+	case errors.Is(err, model.ErrRequestNotFound), errors.Is(err, model.ErrRepoNotFound),
+		errors.Is(err, model.ErrGrantNotFound):
+		s.error(w, r, http.StatusNotFound, err)
+	case errors.Is(err, model.ErrRequestDecided), errors.Is(err, model.ErrIsDirectory),
+		errors.Is(err, model.ErrDestinationTaken):
+		s.error(w, r, http.StatusConflict, err)
+	default:
+		s.error(w, r, status, err)
+	}
+}
+
+func (s *server) userError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, user.ErrInvalidCredentials), errors.Is(err, user.ErrInvalidToken):
+		s.error(w, r, http.StatusUnauthorized, err)
+	case errors.Is(err, user.ErrPermissionDenied), errors.Is(err, user.ErrAccountDisabled),
+		errors.Is(err, user.ErrScopedCredential):
+		s.error(w, r, http.StatusForbidden, err)
+	case errors.Is(err, user.ErrInvalidPassword), errors.Is(err, user.ErrInvalidTitle):
+		s.error(w, r, http.StatusBadRequest, err)
+	case errors.Is(err, user.ErrRecordNotFound):
+		s.error(w, r, http.StatusNotFound, err)
+	case errors.Is(err, user.ErrProtectedRole), errors.Is(err, user.ErrRoleCycle),
+		errors.Is(err, user.ErrRoleInUse), errors.Is(err, user.ErrEmailTaken):
+		s.error(w, r, http.StatusConflict, err)
+	default:
+		s.error(w, r, http.StatusInternalServerError, err)
+	}
+}
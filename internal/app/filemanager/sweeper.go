@@ -0,0 +1,33 @@
+package filemanager
+
+import (
+	"context"
+	"time"
+
+	"files_test_rus/internal/app/file"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sweepExpiredPerms removes expired repository grants right away and then
+// every interval until ctx is done. Evaluation already ignores them; the
+// sweep keeps the perms listings free of grants nobody can use any more.
+func sweepExpiredPerms(ctx context.Context, metadata file.MetadataStore, interval time.Duration, logger *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		removed, err := metadata.RemoveExpiredPerms(ctx)
+		if err != nil {
+			logger.Errorf("failed to remove expired grants. err: %v", err)
+		} else if removed > 0 {
+			logger.Infof("removed %d expired grants", removed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package filemanager
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"files_test_rus/internal/app/user"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+type Claims struct {
+	RoleID string   `json:"role_id"`
+	Email  string   `json:"email"`
+	Groups []string `json:"groups,omitempty"`
+	jwt.StandardClaims
+}
+
+type tokenResponse struct {
+	User        *user.User `json:"user"`
+	AccessToken string     `json:"access_token"`
+	TokenType   string     `json:"token_type"`
+	ExpiresAt   int64      `json:"expires_at"`
+}
+
+var (
+	errMalformedAuthorization = errors.New("malformed authorization header")
+)
+
+const (
+	accessCookie  string = "token"
+	refreshCookie string = "refresh_token"
+
+	accessTokenTTL time.Duration = 15 * time.Minute
+)
+
+// setCookie sets a cookie that is only sent over https, unless configured
+// otherwise, and not on cross-site requests other than top-level
+// navigation, so that other sites cannot post forms with it.
+func (s *server) setCookie(w http.ResponseWriter, cookie *http.Cookie) {
+	cookie.Secure = !s.insecureCookies
+	cookie.SameSite = http.SameSiteLaxMode
+
+	http.SetCookie(w, cookie)
+}
+
+// tokenFromRequest returns the access token sent either as an
+// "Authorization: Bearer" header or, for the browser frontend, as a cookie.
+// The header wins when both are present.
+func tokenFromRequest(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		token = strings.TrimSpace(token)
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return "", errMalformedAuthorization
+		}
+		return token, nil
+	}
+
+	cookie, err := r.Cookie(accessCookie)
+	if err != nil {
+		return "", err
+	}
+
+	return cookie.Value, nil
+}
+
+// issueTokens starts a new session for u: a short-lived access token and a
+// refresh token that can be exchanged for the next one at /auth/refresh.
+func (s *server) issueTokens(w http.ResponseWriter, r *http.Request, u *user.User) (*tokenResponse, error) {
+	resp, err := s.setAccessToken(w, u)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := s.userService.CreateRefreshToken(r.Context(), u.Id)
+	if err != nil {
+		return nil, err
+	}
+	s.setRefreshToken(w, refreshToken)
+
+	return resp, nil
+}
+
+func (s *server) setAccessToken(w http.ResponseWriter, u *user.User) (*tokenResponse, error) {
+	now := time.Now()
+	expiresAt := now.Add(accessTokenTTL)
+	claims := &Claims{
+		RoleID: strconv.Itoa(u.RoleId),
+		Email:  u.Email,
+		Groups: u.Groups,
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewString(),
+			Subject:   strconv.Itoa(u.Id),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}
+
+	tokenStr, err := s.keys.sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	s.setCookie(w, &http.Cookie{
+		Name:     accessCookie,
+		Value:    tokenStr,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+	})
+
+	return &tokenResponse{
+		User:        u,
+		AccessToken: tokenStr,
+		TokenType:   "Bearer",
+		ExpiresAt:   expiresAt.Unix(),
+	}, nil
+}
+
+func (s *server) setRefreshToken(w http.ResponseWriter, token string) {
+	s.setCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Value:    token,
+		Path:     "/auth",
+		Expires:  time.Now().Add(user.RefreshTokenTTL),
+		HttpOnly: true,
+	})
+}
+
+func (s *server) clearTokens(w http.ResponseWriter) {
+	s.setCookie(w, &http.Cookie{
+		Name:     accessCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	s.setCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Value:    "",
+		Path:     "/auth",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
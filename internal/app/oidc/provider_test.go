@@ -0,0 +1,149 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"files_test_rus/internal/app/oidc/oidctest"
+)
+
+// jwksFetches counts the requests for the JWKS of the providers newProvider
+// starts.
+var jwksFetches atomic.Int32
+
+func newProvider(t *testing.T) (*Provider, *oidctest.Provider) {
+	t.Helper()
+
+	idp, err := oidctest.NewProvider("", "filemanager", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.Email = "dev@example.com"
+	idp.Groups = []string{"staff", "ops"}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/jwks" {
+			jwksFetches.Add(1)
+		}
+		idp.Handler().ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	idp.Issuer = srv.URL
+
+	p, err := NewProvider(context.Background(), Config{
+		Issuer:       srv.URL,
+		ClientID:     "filemanager",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/auth/oidc/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p, idp
+}
+
+// login runs the authorization code flow and exchanges the code, sending
+// exchangeNonce instead of the nonce of the authorization request.
+func login(t *testing.T, p *Provider, exchangeNonce string) (*Identity, error) {
+	t.Helper()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(p.AuthCodeURL("state", "nonce"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := location.Query().Get("state"); state != "state" {
+		t.Fatalf("state = %q, want %q", state, "state")
+	}
+
+	return p.Exchange(context.Background(), location.Query().Get("code"), exchangeNonce)
+}
+
+func TestExchange(t *testing.T) {
+	p, idp := newProvider(t)
+
+	identity, err := login(t, p, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if identity.Issuer != idp.Issuer {
+		t.Errorf("Issuer = %q, want %q", identity.Issuer, idp.Issuer)
+	}
+	if identity.Subject != "dev@example.com" || identity.Email != "dev@example.com" {
+		t.Errorf("Subject, Email = %q, %q, want dev@example.com", identity.Subject, identity.Email)
+	}
+	if len(identity.Groups) != 2 || identity.Groups[0] != "staff" || identity.Groups[1] != "ops" {
+		t.Errorf("Groups = %v, want [staff ops]", identity.Groups)
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		nonce  string
+	}{
+		{"unverified email", map[string]interface{}{"email_verified": false}, "nonce"},
+		{"missing email_verified", map[string]interface{}{"email_verified": nil}, "nonce"},
+		{"email_verified as string", map[string]interface{}{"email_verified": "true"}, "nonce"},
+		{"missing email", map[string]interface{}{"email": nil}, "nonce"},
+		{"missing sub", map[string]interface{}{"sub": nil}, "nonce"},
+		{"other audience", map[string]interface{}{"aud": "other"}, "nonce"},
+		{"other issuer", map[string]interface{}{"iss": "https://evil.example.com"}, "nonce"},
+		{"nonce mismatch", nil, "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, idp := newProvider(t)
+			idp.Claims = tt.claims
+
+			if _, err := login(t, p, tt.nonce); !errors.Is(err, ErrInvalidIDToken) {
+				t.Fatalf("err = %v, want %v", err, ErrInvalidIDToken)
+			}
+		})
+	}
+}
+
+func TestUnknownKidRefetch(t *testing.T) {
+	p, _ := newProvider(t)
+	ctx := context.Background()
+	jwksFetches.Store(0)
+
+	if _, err := p.key(ctx, "mockidp"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := p.key(ctx, "unknown"); !errors.Is(err, ErrUnknownKey) {
+			t.Fatalf("err = %v, want %v", err, ErrUnknownKey)
+		}
+	}
+	if n := jwksFetches.Load(); n != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", n)
+	}
+
+	// Once the interval has passed, an unknown kid may pick up a rotated key.
+	p.fetched = time.Now().Add(-jwksRefetchInterval)
+	if _, err := p.key(ctx, "unknown"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("err = %v, want %v", err, ErrUnknownKey)
+	}
+	if n := jwksFetches.Load(); n != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", n)
+	}
+}
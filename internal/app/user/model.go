@@ -0,0 +1,77 @@
+package user
+
+import "time"
+
+type User struct {
+	Id                int      `json:"id"`
+	Email             string   `json:"email"`
+	Password          string   `json:"password,omitempty"`
+	EncryptedPassword string   `json:"-"`
+	RoleId            int      `json:"role_id"`
+	Groups            []string `json:"groups,omitempty"`
+	Disabled          bool     `json:"disabled"`
+}
+
+// Role is granted everything its parent role is granted, and so on up the
+// chain of parents.
+type Role struct {
+	Id       int    `json:"id"`
+	Title    string `json:"title"`
+	ParentId *int   `json:"parent_id,omitempty"`
+}
+
+// Group is a set of users that can be granted access to repository paths in
+// addition to whatever their role is granted.
+type Group struct {
+	Id    int    `json:"id"`
+	Title string `json:"title"`
+}
+
+// ExternalIdentity is a user as an identity provider knows them. Accounts are
+// linked on the issuer and subject; the email may change on the provider side.
+type ExternalIdentity struct {
+	Issuer  string
+	Subject string
+	Email   string
+}
+
+type Login struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type RefreshToken struct {
+	Id        int
+	UserId    int
+	TokenHash string
+	ExpiresAt time.Time
+	Revoked   bool
+}
+
+// SessionState is what decides whether the access tokens of a user still
+// hold: TokenRevoked tells whether the one asked about was logged out.
+type SessionState struct {
+	TokenRevoked      bool
+	Disabled          bool
+	SessionsRevokedAt *time.Time
+}
+
+type ServiceAccount struct {
+	Id        int       `json:"id"`
+	Name      string    `json:"name"`
+	RoleId    int       `json:"role_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ApiKey is a long-lived credential of a service account. Only its hash is
+// stored; Key carries the plaintext value once, in the response to creation.
+type ApiKey struct {
+	Id               int        `json:"id"`
+	ServiceAccountId int        `json:"service_account_id"`
+	Key              string     `json:"key,omitempty"`
+	KeyHash          string     `json:"-"`
+	Prefix           string     `json:"prefix"`
+	Repositories     []string   `json:"repositories"`
+	CreatedAt        time.Time  `json:"created_at"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+}
@@ -0,0 +1,665 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength = 8
+
+	RefreshTokenTTL = 30 * 24 * time.Hour
+
+	// ApiKeyPrefix marks API keys so they can be told apart from JWTs when
+	// both arrive in the same Authorization header.
+	ApiKeyPrefix = "fmk_"
+)
+
+var (
+	ErrRecordNotFound     = errors.New("record not found")
+	ErrInvalidCredentials = errors.New("incorrect email or password")
+	ErrInvalidToken       = errors.New("invalid or expired token")
+	ErrPermissionDenied   = errors.New("permission denied")
+	ErrProtectedRole      = errors.New("the admin role cannot be changed or removed")
+	ErrRoleCycle          = errors.New("a role cannot inherit from itself")
+	ErrScopedCredential   = errors.New("repository scoped credentials cannot be used for administration")
+	ErrRoleInUse          = errors.New("the role is still assigned to users or service accounts")
+	ErrInvalidTitle       = errors.New("title must not be empty")
+	ErrAccountDisabled    = errors.New("account is disabled")
+	ErrEmailTaken         = errors.New("a local account already uses this email")
+	ErrInvalidPassword    = errors.New("password must be at least 8 characters long")
+)
+
+type service struct {
+	storage Client
+	logger  *logrus.Logger
+}
+
+func NewService(userStorage Client, logger *logrus.Logger) (Service, error) {
+	return &service{
+		storage: userStorage,
+		logger:  logger,
+	}, nil
+}
+
+type Service interface {
+	Login(context.Context, Login) (*User, error)
+	ExternalLogin(context.Context, ExternalIdentity, string) (*User, error)
+
+	CreateUser(context.Context, User) (*User, error)
+	GetUsers(context.Context, string) (*[]User, error)
+	FindUser(context.Context, string) (*User, error)
+	SetUserRole(context.Context, int, int) error
+	SetUserDisabled(context.Context, int, bool) error
+	ResetPassword(context.Context, int, string) error
+
+	CreateRole(context.Context, Role) (*Role, error)
+	GetRoles(context.Context) (*[]Role, error)
+	FindRoleByTitle(context.Context, string) (*Role, error)
+	RenameRole(context.Context, Role) error
+	SetRoleParent(context.Context, int, *int) error
+	RemoveRole(context.Context, int) error
+
+	CreateGroup(context.Context, Group) (*Group, error)
+	GetGroups(context.Context) (*[]Group, error)
+	RemoveGroup(context.Context, int) error
+	AddUserToGroup(context.Context, int, int) error
+	RemoveUserFromGroup(context.Context, int, int) error
+
+	CreateRefreshToken(context.Context, int) (string, error)
+	Refresh(context.Context, string) (*User, string, error)
+	RevokeRefreshToken(context.Context, string) error
+
+	RevokeToken(context.Context, string, time.Time) error
+	IsTokenRevoked(context.Context, string, int, time.Time) (bool, error)
+	RevokeSessions(context.Context, int) error
+
+	CreateServiceAccount(context.Context, ServiceAccount) (*ServiceAccount, error)
+	GetServiceAccounts(context.Context) (*[]ServiceAccount, error)
+	CreateApiKey(context.Context, int, []string) (*ApiKey, error)
+	GetApiKeys(context.Context, int) (*[]ApiKey, error)
+	RevokeApiKey(context.Context, int) error
+	AuthenticateApiKey(context.Context, string) (*ServiceAccount, *ApiKey, error)
+}
+
+func (s *service) Login(ctx context.Context, login Login) (*User, error) {
+	u, err := s.storage.FindByEmail(ctx, login.Email)
+	if err != nil {
+		if errors.Is(err, ErrRecordNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(u.EncryptedPassword), []byte(login.Password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if u.Disabled {
+		return nil, ErrAccountDisabled
+	}
+
+	if u.Groups, err = s.storage.GetUserGroups(ctx, u.Id); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// ExternalLogin signs in a user authenticated by an identity provider. The
+// account is created on first login, linked to the provider's issuer and
+// subject, and its role is kept in sync with the role the provider's claims
+// map to; a role change revokes the sessions of the account like SetUserRole
+// does. Local accounts are never taken over: when one already uses the email,
+// the login is refused. External accounts get a random password, so they
+// cannot log in through /auth/login until an admin resets it.
+func (s *service) ExternalLogin(ctx context.Context, identity ExternalIdentity, roleTitle string) (*User, error) {
+	role, err := s.storage.FindRoleByTitle(ctx, roleTitle)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := s.storage.FindByExternalIdentity(ctx, identity.Issuer, identity.Subject)
+	switch {
+	case errors.Is(err, ErrRecordNotFound):
+		if _, err := s.storage.FindByEmail(ctx, identity.Email); err == nil {
+			return nil, ErrEmailTaken
+		} else if !errors.Is(err, ErrRecordNotFound) {
+			return nil, err
+		}
+
+		password, err := randomToken()
+		if err != nil {
+			return nil, err
+		}
+		encrypted, err := encryptPassword(password)
+		if err != nil {
+			return nil, err
+		}
+
+		u = &User{
+			Email:             identity.Email,
+			EncryptedPassword: encrypted,
+			RoleId:            role.Id,
+		}
+		if err := s.storage.CreateExternalUser(ctx, u, identity); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case u.Disabled:
+		return nil, ErrAccountDisabled
+	case u.RoleId != role.Id:
+		if err := s.storage.SetUserRole(ctx, u.Id, role.Id); err != nil {
+			return nil, err
+		}
+		s.logger.Infof("role of user %d changed to %q by the identity provider", u.Id, roleTitle)
+		u.RoleId = role.Id
+	}
+
+	if u.Groups, err = s.storage.GetUserGroups(ctx, u.Id); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (s *service) CreateUser(ctx context.Context, u User) (*User, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	encrypted, err := encryptPassword(u.Password)
+	if err != nil {
+		return nil, err
+	}
+	u.EncryptedPassword = encrypted
+	u.Password = ""
+
+	if err := s.storage.CreateUser(ctx, &u); err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+func (s *service) GetUsers(ctx context.Context, search string) (*[]User, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.storage.GetUsers(ctx, search)
+}
+
+// FindUser looks a user up by id or, when the key is not a number, by email.
+// The groups of the user are loaded as well.
+func (s *service) FindUser(ctx context.Context, key string) (*User, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	var (
+		u   *User
+		err error
+	)
+	if id, convErr := strconv.Atoi(key); convErr == nil {
+		u, err = s.storage.FindById(ctx, id)
+	} else {
+		u, err = s.storage.FindByEmail(ctx, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Groups, err = s.storage.GetUserGroups(ctx, u.Id); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (s *service) SetUserRole(ctx context.Context, userId int, roleId int) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if _, err := s.storage.FindRole(ctx, roleId); err != nil {
+		return err
+	}
+
+	// The storage revokes the sessions too; issued tokens still carry the
+	// old role_id.
+	return s.storage.SetUserRole(ctx, userId, roleId)
+}
+
+func (s *service) SetUserDisabled(ctx context.Context, userId int, disabled bool) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if err := s.storage.SetUserDisabled(ctx, userId, disabled); err != nil {
+		return err
+	}
+
+	if disabled {
+		return s.storage.RevokeSessions(ctx, userId)
+	}
+
+	return nil
+}
+
+func (s *service) ResetPassword(ctx context.Context, userId int, password string) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	encrypted, err := encryptPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if err := s.storage.SetUserPassword(ctx, userId, encrypted); err != nil {
+		return err
+	}
+
+	return s.storage.RevokeSessions(ctx, userId)
+}
+
+func (s *service) CreateRole(ctx context.Context, role Role) (*Role, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(role.Title) == "" {
+		return nil, ErrInvalidTitle
+	}
+
+	if role.ParentId != nil {
+		if _, err := s.storage.FindRole(ctx, *role.ParentId); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.storage.CreateRole(ctx, &role); err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+func (s *service) GetRoles(ctx context.Context) (*[]Role, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.storage.GetRoles(ctx)
+}
+
+func (s *service) FindRoleByTitle(ctx context.Context, title string) (*Role, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.storage.FindRoleByTitle(ctx, title)
+}
+
+// RenameRole changes the title of a role. Repository grants and administrators
+// refer to roles by title, so the storage rewrites them in the same transaction.
+func (s *service) RenameRole(ctx context.Context, role Role) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(role.Title) == "" {
+		return ErrInvalidTitle
+	}
+
+	if err := s.checkProtectedRole(ctx, role.Id); err != nil {
+		return err
+	}
+
+	return s.storage.RenameRole(ctx, role.Id, role.Title)
+}
+
+// SetRoleParent changes the parent role of roleId; a nil parentId makes it a
+// root role again.
+func (s *service) SetRoleParent(ctx context.Context, roleId int, parentId *int) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if err := s.checkProtectedRole(ctx, roleId); err != nil {
+		return err
+	}
+
+	if parentId != nil {
+		if _, err := s.storage.FindRole(ctx, *parentId); err != nil {
+			return err
+		}
+	}
+
+	return s.storage.SetRoleParent(ctx, roleId, parentId)
+}
+
+func (s *service) RemoveRole(ctx context.Context, roleId int) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if err := s.checkProtectedRole(ctx, roleId); err != nil {
+		return err
+	}
+
+	return s.storage.RemoveRole(ctx, roleId)
+}
+
+func (s *service) CreateGroup(ctx context.Context, group Group) (*Group, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := s.storage.CreateGroup(ctx, &group); err != nil {
+		return nil, err
+	}
+
+	return &group, nil
+}
+
+func (s *service) GetGroups(ctx context.Context) (*[]Group, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.storage.GetGroups(ctx)
+}
+
+func (s *service) RemoveGroup(ctx context.Context, groupId int) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	return s.storage.RemoveGroup(ctx, groupId)
+}
+
+// AddUserToGroup and RemoveUserFromGroup take effect with the next access
+// token of the user, i.e. after the next login or refresh.
+func (s *service) AddUserToGroup(ctx context.Context, userId int, groupId int) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	return s.storage.AddUserToGroup(ctx, userId, groupId)
+}
+
+func (s *service) RemoveUserFromGroup(ctx context.Context, userId int, groupId int) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	return s.storage.RemoveUserFromGroup(ctx, userId, groupId)
+}
+
+func (s *service) checkProtectedRole(ctx context.Context, roleId int) error {
+	role, err := s.storage.FindRole(ctx, roleId)
+	if err != nil {
+		return err
+	}
+
+	if role.Title == "admin" {
+		return ErrProtectedRole
+	}
+
+	return nil
+}
+
+func (s *service) CreateRefreshToken(ctx context.Context, userId int) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	rt := &RefreshToken{
+		UserId:    userId,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	}
+	if err := s.storage.CreateRefreshToken(ctx, rt); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Refresh exchanges a refresh token for a new one. The presented token is
+// revoked, so every refresh token can be used exactly once.
+func (s *service) Refresh(ctx context.Context, token string) (*User, string, error) {
+	userId, err := s.storage.UseRefreshToken(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, ErrRecordNotFound) {
+			return nil, "", ErrInvalidToken
+		}
+		return nil, "", err
+	}
+
+	u, err := s.storage.FindById(ctx, userId)
+	if err != nil {
+		if errors.Is(err, ErrRecordNotFound) {
+			return nil, "", ErrInvalidToken
+		}
+		return nil, "", err
+	}
+
+	if u.Disabled {
+		return nil, "", ErrAccountDisabled
+	}
+
+	if u.Groups, err = s.storage.GetUserGroups(ctx, u.Id); err != nil {
+		return nil, "", err
+	}
+
+	newToken, err := s.CreateRefreshToken(ctx, u.Id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return u, newToken, nil
+}
+
+func (s *service) RevokeRefreshToken(ctx context.Context, token string) error {
+	return s.storage.RevokeRefreshToken(ctx, hashToken(token))
+}
+
+func (s *service) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return nil
+	}
+
+	return s.storage.RevokeToken(ctx, jti, expiresAt)
+}
+
+// IsTokenRevoked reports whether an access token can no longer be used: it
+// was logged out, its user is disabled or gone, or the sessions of the user
+// were revoked after it was issued.
+func (s *service) IsTokenRevoked(ctx context.Context, jti string, userId int, issuedAt time.Time) (bool, error) {
+	state, err := s.storage.GetSessionState(ctx, jti, userId)
+	if errors.Is(err, ErrRecordNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return state.TokenRevoked || state.Disabled || issuedBeforeRevocation(issuedAt, state.SessionsRevokedAt), nil
+}
+
+// issuedBeforeRevocation compares in whole seconds, since that is all the
+// issue time of a token carries. A token issued in the second of the
+// revocation stays valid, so that the tokens of a login that revoked the
+// sessions itself, by changing the role, work right away.
+func issuedBeforeRevocation(issuedAt time.Time, revokedAt *time.Time) bool {
+	return revokedAt != nil && revokedAt.Truncate(time.Second).After(issuedAt)
+}
+
+func (s *service) RevokeSessions(ctx context.Context, userId int) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	return s.storage.RevokeSessions(ctx, userId)
+}
+
+func (s *service) CreateServiceAccount(ctx context.Context, sa ServiceAccount) (*ServiceAccount, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := s.storage.CreateServiceAccount(ctx, &sa); err != nil {
+		return nil, err
+	}
+
+	return &sa, nil
+}
+
+func (s *service) GetServiceAccounts(ctx context.Context) (*[]ServiceAccount, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.storage.GetServiceAccounts(ctx)
+}
+
+func (s *service) CreateApiKey(ctx context.Context, accountId int, repositories []string) (*ApiKey, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.storage.FindServiceAccount(ctx, accountId); err != nil {
+		return nil, err
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	key := ApiKeyPrefix + token
+
+	if repositories == nil {
+		repositories = []string{}
+	}
+
+	k := &ApiKey{
+		ServiceAccountId: accountId,
+		Key:              key,
+		KeyHash:          hashToken(key),
+		Prefix:           key[:len(ApiKeyPrefix)+6],
+		Repositories:     repositories,
+	}
+	if err := s.storage.CreateApiKey(ctx, k); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func (s *service) GetApiKeys(ctx context.Context, accountId int) (*[]ApiKey, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.storage.GetApiKeys(ctx, accountId)
+}
+
+func (s *service) RevokeApiKey(ctx context.Context, keyId int) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	return s.storage.RevokeApiKey(ctx, keyId)
+}
+
+func (s *service) AuthenticateApiKey(ctx context.Context, key string) (*ServiceAccount, *ApiKey, error) {
+	if !strings.HasPrefix(key, ApiKeyPrefix) {
+		return nil, nil, ErrInvalidToken
+	}
+
+	k, err := s.storage.FindApiKey(ctx, hashToken(key))
+	if err != nil {
+		if errors.Is(err, ErrRecordNotFound) {
+			return nil, nil, ErrInvalidToken
+		}
+		return nil, nil, err
+	}
+
+	if k.RevokedAt != nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	sa, err := s.storage.FindServiceAccount(ctx, k.ServiceAccountId)
+	if err != nil {
+		if errors.Is(err, ErrRecordNotFound) {
+			return nil, nil, ErrInvalidToken
+		}
+		return nil, nil, err
+	}
+
+	return sa, k, nil
+}
+
+// requireAdmin allows the call only for the admin role on an unscoped
+// credential. A key scoped to some repositories must not manage users or
+// mint keys, or it could hand out access beyond its own scope.
+func (s *service) requireAdmin(ctx context.Context) error {
+	if repos, _ := ctx.Value("repositories").([]string); len(repos) > 0 {
+		return ErrScopedCredential
+	}
+
+	roleId, _ := ctx.Value("role").(string)
+
+	title, err := s.storage.GetRoleTitle(ctx, roleId)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return err
+	}
+
+	if title != "admin" {
+		return ErrPermissionDenied
+	}
+
+	return nil
+}
+
+func encryptPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", ErrInvalidPassword
+	}
+
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
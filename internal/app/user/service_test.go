@@ -0,0 +1,137 @@
+package user
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeStorage keeps one user and the sessions state of it. Methods the tests
+// do not reach are left to the embedded nil Client.
+type fakeStorage struct {
+	Client
+
+	user      User
+	roles     map[string]int
+	revokedAt *time.Time
+}
+
+func (f *fakeStorage) FindRoleByTitle(ctx context.Context, title string) (*Role, error) {
+	id, ok := f.roles[title]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &Role{Id: id, Title: title}, nil
+}
+
+func (f *fakeStorage) FindByExternalIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	u := f.user
+	return &u, nil
+}
+
+func (f *fakeStorage) SetUserRole(ctx context.Context, id int, roleId int) error {
+	f.user.RoleId = roleId
+	return f.RevokeSessions(ctx, id)
+}
+
+func (f *fakeStorage) RevokeSessions(ctx context.Context, id int) error {
+	now := time.Now()
+	f.revokedAt = &now
+	return nil
+}
+
+func (f *fakeStorage) GetUserGroups(ctx context.Context, id int) ([]string, error) {
+	return nil, nil
+}
+
+func (f *fakeStorage) GetSessionState(ctx context.Context, jti string, userId int) (*SessionState, error) {
+	if userId != f.user.Id {
+		return nil, ErrRecordNotFound
+	}
+
+	return &SessionState{SessionsRevokedAt: f.revokedAt}, nil
+}
+
+func newTestService(t *testing.T) (*service, *fakeStorage) {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	storage := &fakeStorage{
+		user:  User{Id: 7, Email: "dev@example.com", RoleId: 1},
+		roles: map[string]int{"viewer": 1, "editor": 2},
+	}
+
+	return &service{storage: storage, logger: logger}, storage
+}
+
+// issuedNow is the issue time a token created now carries.
+func issuedNow() time.Time {
+	return time.Unix(time.Now().Unix(), 0)
+}
+
+func TestLoginAfterRevoke(t *testing.T) {
+	s, storage := newTestService(t)
+	ctx := context.Background()
+
+	if err := storage.RevokeSessions(ctx, storage.user.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, err := s.IsTokenRevoked(ctx, "jti", storage.user.Id, issuedNow())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked {
+		t.Error("token issued right after the revocation is revoked")
+	}
+
+	revoked, err = s.IsTokenRevoked(ctx, "jti", storage.user.Id, issuedNow().Add(-2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Error("token issued before the revocation is not revoked")
+	}
+}
+
+func TestExternalLoginChangingRole(t *testing.T) {
+	s, storage := newTestService(t)
+	ctx := context.Background()
+
+	u, err := s.ExternalLogin(ctx, ExternalIdentity{Issuer: "idp", Subject: "dev", Email: "dev@example.com"}, "editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.RoleId != 2 {
+		t.Fatalf("RoleId = %d, want 2", u.RoleId)
+	}
+	if storage.revokedAt == nil {
+		t.Fatal("changing the role did not revoke the sessions")
+	}
+
+	revoked, err := s.IsTokenRevoked(ctx, "jti", u.Id, issuedNow())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked {
+		t.Error("token of the login that changed the role is revoked")
+	}
+}
+
+func TestTokenOfMissingUserIsRevoked(t *testing.T) {
+	s, _ := newTestService(t)
+
+	revoked, err := s.IsTokenRevoked(context.Background(), "jti", 8, issuedNow())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Error("token of a missing user is not revoked")
+	}
+}
@@ -0,0 +1,50 @@
+package user
+
+import (
+	"context"
+	"time"
+)
+
+type Client interface {
+	FindById(context.Context, int) (*User, error)
+	FindByEmail(context.Context, string) (*User, error)
+	FindByExternalIdentity(context.Context, string, string) (*User, error)
+	CreateUser(context.Context, *User) error
+	CreateExternalUser(context.Context, *User, ExternalIdentity) error
+	GetUsers(context.Context, string) (*[]User, error)
+	SetUserRole(context.Context, int, int) error
+	SetUserDisabled(context.Context, int, bool) error
+	SetUserPassword(context.Context, int, string) error
+	GetRoleTitle(context.Context, string) (string, error)
+
+	CreateRole(context.Context, *Role) error
+	FindRole(context.Context, int) (*Role, error)
+	FindRoleByTitle(context.Context, string) (*Role, error)
+	GetRoles(context.Context) (*[]Role, error)
+	SetRoleParent(context.Context, int, *int) error
+	RenameRole(context.Context, int, string) error
+	RemoveRole(context.Context, int) error
+
+	CreateGroup(context.Context, *Group) error
+	GetGroups(context.Context) (*[]Group, error)
+	RemoveGroup(context.Context, int) error
+	GetUserGroups(context.Context, int) ([]string, error)
+	AddUserToGroup(context.Context, int, int) error
+	RemoveUserFromGroup(context.Context, int, int) error
+
+	CreateRefreshToken(context.Context, *RefreshToken) error
+	UseRefreshToken(context.Context, string) (int, error)
+	RevokeRefreshToken(context.Context, string) error
+
+	RevokeToken(context.Context, string, time.Time) error
+	GetSessionState(context.Context, string, int) (*SessionState, error)
+	RevokeSessions(context.Context, int) error
+
+	CreateServiceAccount(context.Context, *ServiceAccount) error
+	FindServiceAccount(context.Context, int) (*ServiceAccount, error)
+	GetServiceAccounts(context.Context) (*[]ServiceAccount, error)
+	CreateApiKey(context.Context, *ApiKey) error
+	FindApiKey(context.Context, string) (*ApiKey, error)
+	GetApiKeys(context.Context, int) (*[]ApiKey, error)
+	RevokeApiKey(context.Context, int) error
+}
@@ -0,0 +1,661 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	model "files_test_rus/internal/app/user"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+type Client struct {
+	db     *sql.DB
+	logger *logrus.Logger
+}
+
+func NewClient(db *sql.DB, logger *logrus.Logger) *Client {
+	return &Client{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (c *Client) FindById(ctx context.Context, id int) (*model.User, error) {
+	u := &model.User{}
+	if err := c.db.QueryRow(
+		"SELECT id, email, encrypted_password, role_id, disabled FROM users WHERE id = $1",
+		id,
+	).Scan(&u.Id, &u.Email, &u.EncryptedPassword, &u.RoleId, &u.Disabled); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, model.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (c *Client) FindByEmail(ctx context.Context, email string) (*model.User, error) {
+	u := &model.User{}
+	if err := c.db.QueryRow(
+		"SELECT id, email, encrypted_password, role_id, disabled FROM users WHERE email = $1",
+		email,
+	).Scan(&u.Id, &u.Email, &u.EncryptedPassword, &u.RoleId, &u.Disabled); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, model.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (c *Client) FindByExternalIdentity(ctx context.Context, issuer string, subject string) (*model.User, error) {
+	u := &model.User{}
+	if err := c.db.QueryRow(
+		`SELECT u.id, u.email, u.encrypted_password, u.role_id, u.disabled
+		FROM users u JOIN external_identities e ON e.user_id = u.id
+		WHERE e.issuer = $1 AND e.subject = $2`,
+		issuer,
+		subject,
+	).Scan(&u.Id, &u.Email, &u.EncryptedPassword, &u.RoleId, &u.Disabled); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, model.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (c *Client) CreateUser(ctx context.Context, u *model.User) error {
+	return c.db.QueryRow(
+		"INSERT INTO users (email, encrypted_password, role_id) VALUES ($1, $2, $3) RETURNING id",
+		u.Email,
+		u.EncryptedPassword,
+		u.RoleId,
+	).Scan(&u.Id)
+}
+
+// CreateExternalUser creates the user and links it to the identity in one
+// transaction.
+func (c *Client) CreateExternalUser(ctx context.Context, u *model.User, identity model.ExternalIdentity) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow(
+		"INSERT INTO users (email, encrypted_password, role_id) VALUES ($1, $2, $3) RETURNING id",
+		u.Email,
+		u.EncryptedPassword,
+		u.RoleId,
+	).Scan(&u.Id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO external_identities (issuer, subject, user_id) VALUES ($1, $2, $3)",
+		identity.Issuer,
+		identity.Subject,
+		u.Id,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// likeEscaper escapes the LIKE wildcards, so a search matches its text
+// literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (c *Client) GetUsers(ctx context.Context, search string) (*[]model.User, error) {
+	rows, err := c.db.Query(
+		"SELECT id, email, role_id, disabled FROM users WHERE email ILIKE '%' || $1 || '%' ORDER BY id",
+		likeEscaper.Replace(search),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []model.User{}
+	for rows.Next() {
+		var u model.User
+		if err := rows.Scan(&u.Id, &u.Email, &u.RoleId, &u.Disabled); err != nil {
+			return &users, err
+		}
+		users = append(users, u)
+	}
+
+	return &users, rows.Err()
+}
+
+// SetUserRole changes the role of a user and revokes its sessions in the
+// same transaction, since issued tokens still carry the old role.
+func (c *Client) SetUserRole(ctx context.Context, id int, roleId int) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE users SET role_id = $1 WHERE id = $2", roleId, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return model.ErrRecordNotFound
+	}
+
+	if err := revokeSessions(tx, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (c *Client) SetUserDisabled(ctx context.Context, id int, disabled bool) error {
+	return c.updateUser("UPDATE users SET disabled = $1 WHERE id = $2", disabled, id)
+}
+
+func (c *Client) SetUserPassword(ctx context.Context, id int, encryptedPassword string) error {
+	return c.updateUser("UPDATE users SET encrypted_password = $1 WHERE id = $2", encryptedPassword, id)
+}
+
+func (c *Client) updateUser(query string, args ...interface{}) error {
+	res, err := c.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return model.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (c *Client) GetRoleTitle(ctx context.Context, roleId string) (string, error) {
+	var title string
+	if err := c.db.QueryRow(
+		"SELECT title FROM roles WHERE id::text = $1",
+		roleId,
+	).Scan(&title); err != nil {
+		if err == sql.ErrNoRows {
+			return "", model.ErrRecordNotFound
+		}
+		return "", err
+	}
+
+	return title, nil
+}
+
+func (c *Client) CreateRole(ctx context.Context, role *model.Role) error {
+	return c.db.QueryRow(
+		"INSERT INTO roles (title, parent_id) VALUES ($1, $2) RETURNING id",
+		role.Title,
+		role.ParentId,
+	).Scan(&role.Id)
+}
+
+func (c *Client) FindRole(ctx context.Context, id int) (*model.Role, error) {
+	role := &model.Role{}
+	if err := c.db.QueryRow(
+		"SELECT id, title, parent_id FROM roles WHERE id = $1",
+		id,
+	).Scan(&role.Id, &role.Title, &role.ParentId); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, model.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return role, nil
+}
+
+func (c *Client) FindRoleByTitle(ctx context.Context, title string) (*model.Role, error) {
+	role := &model.Role{}
+	if err := c.db.QueryRow(
+		"SELECT id, title, parent_id FROM roles WHERE title = $1",
+		title,
+	).Scan(&role.Id, &role.Title, &role.ParentId); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, model.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return role, nil
+}
+
+func (c *Client) GetRoles(ctx context.Context) (*[]model.Role, error) {
+	rows, err := c.db.Query("SELECT id, title, parent_id FROM roles ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []model.Role{}
+	for rows.Next() {
+		var role model.Role
+		if err := rows.Scan(&role.Id, &role.Title, &role.ParentId); err != nil {
+			return &roles, err
+		}
+		roles = append(roles, role)
+	}
+
+	return &roles, rows.Err()
+}
+
+// SetRoleParent makes parentId the parent of role id, or clears the parent
+// when parentId is nil. ErrRoleCycle is returned when id is parentId itself
+// or one of its ancestors.
+func (c *Client) SetRoleParent(ctx context.Context, id int, parentId *int) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if parentId != nil {
+		// Lock the roles so no concurrent change closes a cycle behind us.
+		if _, err := tx.Exec("LOCK TABLE roles IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+			return err
+		}
+
+		var cycle bool
+		if err := tx.QueryRow(`WITH RECURSIVE ancestors AS (
+				SELECT id, parent_id FROM roles WHERE id = $1
+				UNION
+				SELECT r.id, r.parent_id FROM roles r JOIN ancestors a ON r.id = a.parent_id
+			)
+			SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)`,
+			*parentId, id,
+		).Scan(&cycle); err != nil {
+			return err
+		}
+		if cycle {
+			return model.ErrRoleCycle
+		}
+	}
+
+	res, err := tx.Exec("UPDATE roles SET parent_id = $1 WHERE id = $2", parentId, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return model.ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (c *Client) RenameRole(ctx context.Context, id int, title string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old string
+	if err := tx.QueryRow("SELECT title FROM roles WHERE id = $1 FOR UPDATE", id).Scan(&old); err != nil {
+		if err == sql.ErrNoRows {
+			return model.ErrRecordNotFound
+		}
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE roles SET title = $1 WHERE id = $2", title, id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE grants SET subject = $1 WHERE subject_type = 'role' AND subject = $2", title, old); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE repository_admins SET subject = $1 WHERE subject_type = 'role' AND subject = $2", title, old); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (c *Client) RemoveRole(ctx context.Context, id int) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var title string
+	if err := tx.QueryRow("SELECT title FROM roles WHERE id = $1 FOR UPDATE", id).Scan(&title); err != nil {
+		if err == sql.ErrNoRows {
+			return model.ErrRecordNotFound
+		}
+		return err
+	}
+
+	var inUse bool
+	if err := tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM users WHERE role_id = $1)
+			OR EXISTS (SELECT 1 FROM service_accounts WHERE role_id = $1)`,
+		id,
+	).Scan(&inUse); err != nil {
+		return err
+	}
+	if inUse {
+		return model.ErrRoleInUse
+	}
+
+	if _, err := tx.Exec("DELETE FROM roles WHERE id = $1", id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM grants WHERE subject_type = 'role' AND subject = $1", title); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM repository_admins WHERE subject_type = 'role' AND subject = $1", title); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (c *Client) CreateGroup(ctx context.Context, group *model.Group) error {
+	return c.db.QueryRow(
+		"INSERT INTO groups (title) VALUES ($1) RETURNING id",
+		group.Title,
+	).Scan(&group.Id)
+}
+
+func (c *Client) GetGroups(ctx context.Context) (*[]model.Group, error) {
+	rows, err := c.db.Query("SELECT id, title FROM groups ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []model.Group{}
+	for rows.Next() {
+		var group model.Group
+		if err := rows.Scan(&group.Id, &group.Title); err != nil {
+			return &groups, err
+		}
+		groups = append(groups, group)
+	}
+
+	return &groups, rows.Err()
+}
+
+func (c *Client) RemoveGroup(ctx context.Context, id int) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var title string
+	if err := tx.QueryRow("DELETE FROM groups WHERE id = $1 RETURNING title", id).Scan(&title); err != nil {
+		if err == sql.ErrNoRows {
+			return model.ErrRecordNotFound
+		}
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM grants WHERE subject_type = 'group' AND subject = $1", title); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM repository_admins WHERE subject_type = 'group' AND subject = $1", title); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (c *Client) GetUserGroups(ctx context.Context, userId int) ([]string, error) {
+	rows, err := c.db.Query(
+		"SELECT g.title FROM groups g JOIN user_groups ug ON ug.group_id = g.id WHERE ug.user_id = $1 ORDER BY g.title",
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []string
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			return nil, err
+		}
+		groups = append(groups, title)
+	}
+
+	return groups, rows.Err()
+}
+
+func (c *Client) AddUserToGroup(ctx context.Context, userId int, groupId int) error {
+	if _, err := c.db.Exec(
+		"INSERT INTO user_groups (user_id, group_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		userId,
+		groupId,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) RemoveUserFromGroup(ctx context.Context, userId int, groupId int) error {
+	return c.updateUser("DELETE FROM user_groups WHERE user_id = $1 AND group_id = $2", userId, groupId)
+}
+
+func (c *Client) CreateRefreshToken(ctx context.Context, rt *model.RefreshToken) error {
+	return c.db.QueryRow(
+		"INSERT INTO refresh_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3) RETURNING id",
+		rt.UserId,
+		rt.TokenHash,
+		rt.ExpiresAt,
+	).Scan(&rt.Id)
+}
+
+// UseRefreshToken revokes a valid refresh token and returns the id of its
+// user. Checking and revoking is one statement, so of two concurrent uses of
+// the same token only one succeeds.
+func (c *Client) UseRefreshToken(ctx context.Context, tokenHash string) (int, error) {
+	var userId int
+	if err := c.db.QueryRow(
+		"UPDATE refresh_tokens SET revoked = true WHERE token_hash = $1 AND NOT revoked AND expires_at > now() RETURNING user_id",
+		tokenHash,
+	).Scan(&userId); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, model.ErrRecordNotFound
+		}
+		return 0, err
+	}
+
+	return userId, nil
+}
+
+func (c *Client) RevokeRefreshToken(ctx context.Context, tokenHash string) error {
+	if _, err := c.db.Exec(
+		"UPDATE refresh_tokens SET revoked = true WHERE token_hash = $1",
+		tokenHash,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if _, err := c.db.Exec("DELETE FROM revoked_tokens WHERE expires_at < now()"); err != nil {
+		c.logger.Warnf("failed to prune revoked tokens. err: %v", err)
+	}
+
+	if _, err := c.db.Exec(
+		"INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING",
+		jti,
+		expiresAt,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) GetSessionState(ctx context.Context, jti string, userId int) (*model.SessionState, error) {
+	state := &model.SessionState{}
+	if err := c.db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1), disabled, sessions_revoked_at
+		FROM users WHERE id = $2`,
+		jti,
+		userId,
+	).Scan(&state.TokenRevoked, &state.Disabled, &state.SessionsRevokedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, model.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return state, nil
+}
+
+func (c *Client) RevokeSessions(ctx context.Context, userId int) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := revokeSessions(tx, userId); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func revokeSessions(tx *sql.Tx, userId int) error {
+	res, err := tx.Exec("UPDATE users SET sessions_revoked_at = now() WHERE id = $1", userId)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return model.ErrRecordNotFound
+	}
+
+	_, err = tx.Exec("UPDATE refresh_tokens SET revoked = true WHERE user_id = $1", userId)
+
+	return err
+}
+
+func (c *Client) CreateServiceAccount(ctx context.Context, sa *model.ServiceAccount) error {
+	return c.db.QueryRow(
+		"INSERT INTO service_accounts (name, role_id) VALUES ($1, $2) RETURNING id, created_at",
+		sa.Name,
+		sa.RoleId,
+	).Scan(&sa.Id, &sa.CreatedAt)
+}
+
+func (c *Client) FindServiceAccount(ctx context.Context, id int) (*model.ServiceAccount, error) {
+	sa := &model.ServiceAccount{}
+	if err := c.db.QueryRow(
+		"SELECT id, name, role_id, created_at FROM service_accounts WHERE id = $1",
+		id,
+	).Scan(&sa.Id, &sa.Name, &sa.RoleId, &sa.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, model.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return sa, nil
+}
+
+func (c *Client) GetServiceAccounts(ctx context.Context) (*[]model.ServiceAccount, error) {
+	rows, err := c.db.Query("SELECT id, name, role_id, created_at FROM service_accounts ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []model.ServiceAccount{}
+	for rows.Next() {
+		var sa model.ServiceAccount
+		if err := rows.Scan(&sa.Id, &sa.Name, &sa.RoleId, &sa.CreatedAt); err != nil {
+			return &accounts, err
+		}
+		accounts = append(accounts, sa)
+	}
+
+	return &accounts, rows.Err()
+}
+
+func (c *Client) CreateApiKey(ctx context.Context, k *model.ApiKey) error {
+	return c.db.QueryRow(
+		"INSERT INTO api_keys (service_account_id, key_hash, key_prefix, repositories) VALUES ($1, $2, $3, $4) RETURNING id, created_at",
+		k.ServiceAccountId,
+		k.KeyHash,
+		k.Prefix,
+		pq.Array(k.Repositories),
+	).Scan(&k.Id, &k.CreatedAt)
+}
+
+func (c *Client) FindApiKey(ctx context.Context, keyHash string) (*model.ApiKey, error) {
+	k := &model.ApiKey{}
+	if err := c.db.QueryRow(
+		"SELECT id, service_account_id, key_hash, key_prefix, repositories, created_at, revoked_at FROM api_keys WHERE key_hash = $1",
+		keyHash,
+	).Scan(&k.Id, &k.ServiceAccountId, &k.KeyHash, &k.Prefix, pq.Array(&k.Repositories), &k.CreatedAt, &k.RevokedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, model.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func (c *Client) GetApiKeys(ctx context.Context, accountId int) (*[]model.ApiKey, error) {
+	rows, err := c.db.Query(
+		"SELECT id, service_account_id, key_prefix, repositories, created_at, revoked_at FROM api_keys WHERE service_account_id = $1 ORDER BY id",
+		accountId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []model.ApiKey{}
+	for rows.Next() {
+		var k model.ApiKey
+		if err := rows.Scan(&k.Id, &k.ServiceAccountId, &k.Prefix, pq.Array(&k.Repositories), &k.CreatedAt, &k.RevokedAt); err != nil {
+			return &keys, err
+		}
+		keys = append(keys, k)
+	}
+
+	return &keys, rows.Err()
+}
+
+func (c *Client) RevokeApiKey(ctx context.Context, keyId int) error {
+	res, err := c.db.Exec("UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL", keyId)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return model.ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,5 @@
+ALTER TABLE users DROP COLUMN sessions_revoked_at;
+
+DROP TABLE revoked_tokens;
+
+DROP TABLE refresh_tokens;
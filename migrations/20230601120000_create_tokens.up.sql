@@ -0,0 +1,16 @@
+CREATE TABLE refresh_tokens (
+    id bigserial not null primary key,
+    user_id bigint not null,
+    token_hash varchar not null unique,
+    expires_at timestamptz not null,
+    revoked boolean not null default false
+);
+
+CREATE TABLE revoked_tokens (
+    jti varchar not null primary key,
+    expires_at timestamptz not null
+);
+
+ALTER TABLE users ADD COLUMN sessions_revoked_at timestamptz;
+
+ALTER TABLE refresh_tokens ADD FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
@@ -0,0 +1,20 @@
+CREATE TABLE service_accounts (
+    id bigserial not null primary key,
+    name varchar not null unique,
+    role_id bigint not null,
+    created_at timestamptz not null default now()
+);
+
+CREATE TABLE api_keys (
+    id bigserial not null primary key,
+    service_account_id bigint not null,
+    key_hash varchar not null unique,
+    key_prefix varchar not null,
+    repositories varchar[] not null default '{}',
+    created_at timestamptz not null default now(),
+    revoked_at timestamptz
+);
+
+ALTER TABLE service_accounts ADD FOREIGN KEY (role_id) REFERENCES roles (id);
+
+ALTER TABLE api_keys ADD FOREIGN KEY (service_account_id) REFERENCES service_accounts (id) ON DELETE CASCADE;
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN disabled boolean not null default false;
@@ -0,0 +1,14 @@
+CREATE TABLE groups (
+    id bigserial not null primary key,
+    title varchar not null unique
+);
+
+CREATE TABLE user_groups (
+    user_id bigint not null,
+    group_id bigint not null,
+    primary key (user_id, group_id)
+);
+
+ALTER TABLE user_groups ADD FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+
+ALTER TABLE user_groups ADD FOREIGN KEY (group_id) REFERENCES groups (id) ON DELETE CASCADE;
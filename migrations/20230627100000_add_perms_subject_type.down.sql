@@ -0,0 +1,12 @@
+DO $$
+DECLARE
+    r record;
+BEGIN
+    FOR r IN SELECT lower(repo) || '_perms' AS perms FROM repositories
+        WHERE repo ~ '^[A-Za-z_][A-Za-z0-9_$]*$' AND octet_length(repo) <= 57
+        AND to_regclass(quote_ident(lower(repo) || '_perms')) IS NOT NULL
+    LOOP
+        EXECUTE format('DELETE FROM %I WHERE subject_type = ''user''', r.perms);
+        EXECUTE format('ALTER TABLE %I DROP COLUMN subject_type', r.perms);
+    END LOOP;
+END $$;
@@ -0,0 +1,15 @@
+DO $$
+DECLARE
+    r record;
+BEGIN
+    -- Repository tables were created with unquoted names, so Postgres folded
+    -- them to lower case, and names that are no identifier or a reserved
+    -- word never got one.
+    FOR r IN SELECT lower(repo) || '_perms' AS perms FROM repositories
+        WHERE repo ~ '^[A-Za-z_][A-Za-z0-9_$]*$' AND octet_length(repo) <= 57
+        AND to_regclass(quote_ident(lower(repo) || '_perms')) IS NOT NULL
+    LOOP
+        EXECUTE format('ALTER TABLE %I ADD COLUMN subject_type varchar not null default ''role''', r.perms);
+        EXECUTE format('UPDATE %I SET subject_type = ''group'' WHERE role_title IN (SELECT title FROM groups) AND role_title NOT IN (SELECT title FROM roles)', r.perms);
+    END LOOP;
+END $$;
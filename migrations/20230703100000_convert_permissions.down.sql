@@ -0,0 +1,19 @@
+CREATE FUNCTION pg_temp.convert_permission(new varchar) RETURNS varchar AS $$
+    SELECT concat(
+        CASE WHEN ',' || new || ',' LIKE '%,list,%' THEN 'r' ELSE '-' END,
+        CASE WHEN ',' || new || ',' LIKE '%,write,%' THEN 'w' ELSE '-' END,
+        CASE WHEN ',' || new || ',' LIKE '%,read,%' THEN 'd' ELSE '-' END
+    );
+$$ LANGUAGE sql IMMUTABLE;
+
+DO $$
+DECLARE
+    r record;
+BEGIN
+    FOR r IN SELECT lower(repo) || '_perms' AS perms FROM repositories
+        WHERE repo ~ '^[A-Za-z_][A-Za-z0-9_$]*$' AND octet_length(repo) <= 57
+        AND to_regclass(quote_ident(lower(repo) || '_perms')) IS NOT NULL
+    LOOP
+        EXECUTE format('UPDATE %I SET permission = pg_temp.convert_permission(permission)', r.perms);
+    END LOOP;
+END $$;
@@ -0,0 +1,27 @@
+CREATE FUNCTION pg_temp.convert_permission(old varchar) RETURNS varchar AS $$
+    SELECT nullif(concat_ws(',',
+        CASE WHEN substr(old, 1, 1) = 'r' THEN 'list' END,
+        CASE WHEN substr(old, 3, 1) = 'd' THEN 'read' END,
+        CASE WHEN substr(old, 2, 1) = 'w' THEN 'write' END,
+        CASE WHEN substr(old, 2, 1) = 'w' THEN 'delete' END
+    ), '');
+$$ LANGUAGE sql IMMUTABLE;
+
+DO $$
+DECLARE
+    r record;
+    g record;
+BEGIN
+    FOR r IN SELECT lower(repo) || '_perms' AS perms FROM repositories
+        WHERE repo ~ '^[A-Za-z_][A-Za-z0-9_$]*$' AND octet_length(repo) <= 57
+        AND to_regclass(quote_ident(lower(repo) || '_perms')) IS NOT NULL
+    LOOP
+        -- A grant of '---' granted nothing. There is no permission it could
+        -- become, so it is dropped rather than widened to list.
+        FOR g IN EXECUTE format('DELETE FROM %I WHERE pg_temp.convert_permission(permission) IS NULL RETURNING id, subject_type, role_title, path, permission', r.perms) LOOP
+            RAISE NOTICE '%: dropped grant % of % to % % on %', r.perms, g.id, g.permission, g.subject_type, g.role_title, g.path;
+        END LOOP;
+
+        EXECUTE format('UPDATE %I SET permission = pg_temp.convert_permission(permission)', r.perms);
+    END LOOP;
+END $$;
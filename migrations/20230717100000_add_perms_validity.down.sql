@@ -0,0 +1,11 @@
+DO $$
+DECLARE
+    r record;
+BEGIN
+    FOR r IN SELECT lower(repo) || '_perms' AS perms FROM repositories
+        WHERE repo ~ '^[A-Za-z_][A-Za-z0-9_$]*$' AND octet_length(repo) <= 57
+        AND to_regclass(quote_ident(lower(repo) || '_perms')) IS NOT NULL
+    LOOP
+        EXECUTE format('ALTER TABLE %I DROP COLUMN valid_from, DROP COLUMN valid_until', r.perms);
+    END LOOP;
+END $$;
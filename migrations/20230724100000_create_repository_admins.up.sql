@@ -0,0 +1,9 @@
+CREATE TABLE repository_admins (
+    repo_id bigint not null,
+    subject_type varchar not null default 'role',
+    subject varchar not null,
+    level varchar not null,
+    primary key (repo_id, subject_type, subject)
+);
+
+ALTER TABLE repository_admins ADD FOREIGN KEY (repo_id) REFERENCES repositories (id) ON DELETE CASCADE;
@@ -0,0 +1,3 @@
+ALTER TABLE roles ADD COLUMN parent_id bigint;
+
+ALTER TABLE roles ADD FOREIGN KEY (parent_id) REFERENCES roles (id) ON DELETE SET NULL;
@@ -0,0 +1,3 @@
+DROP TABLE perms_audit;
+
+DROP TABLE access_requests;
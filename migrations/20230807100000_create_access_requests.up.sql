@@ -0,0 +1,33 @@
+CREATE TABLE access_requests (
+    id bigserial not null primary key,
+    user_id bigint not null,
+    email varchar not null,
+    repo varchar not null,
+    path varchar not null,
+    permission varchar not null,
+    justification text not null default '',
+    status varchar not null default 'pending',
+    created_at timestamptz not null default now(),
+    decided_by bigint,
+    decided_at timestamptz,
+    note text not null default ''
+);
+
+ALTER TABLE access_requests ADD FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+
+CREATE INDEX access_requests_status_idx ON access_requests (status);
+
+-- perms_audit records the decisions on access requests, not every change of
+-- the grants.
+CREATE TABLE perms_audit (
+    id bigserial not null primary key,
+    actor varchar not null,
+    action varchar not null,
+    repo varchar not null,
+    subject_type varchar not null,
+    subject varchar not null,
+    path varchar not null,
+    permission varchar not null,
+    access_request_id bigint,
+    created_at timestamptz not null default now()
+);
@@ -0,0 +1,25 @@
+CREATE TABLE nodes (
+    id bigserial not null primary key,
+    repository_id bigint not null,
+    path varchar not null,
+    unique (repository_id, path)
+);
+
+ALTER TABLE nodes ADD FOREIGN KEY (repository_id) REFERENCES repositories (id) ON DELETE CASCADE;
+
+CREATE TABLE grants (
+    id bigserial not null primary key,
+    repository_id bigint not null,
+    subject_type varchar not null default 'role',
+    subject varchar not null,
+    path varchar not null,
+    permission varchar not null,
+    effect varchar not null default 'allow',
+    valid_from timestamptz,
+    valid_until timestamptz
+);
+
+ALTER TABLE grants ADD FOREIGN KEY (repository_id) REFERENCES repositories (id) ON DELETE CASCADE;
+
+CREATE INDEX grants_repository_path_idx ON grants (repository_id, path);
+CREATE INDEX grants_subject_idx ON grants (subject_type, subject);
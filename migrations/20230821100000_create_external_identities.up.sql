@@ -0,0 +1,8 @@
+CREATE TABLE external_identities (
+    issuer varchar not null,
+    subject varchar not null,
+    user_id bigint not null,
+    primary key (issuer, subject)
+);
+
+ALTER TABLE external_identities ADD FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;